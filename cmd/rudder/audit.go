@@ -0,0 +1,222 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	releaseAPI "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// auditRecord is the audit trail of a single RPC which changes a release
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	RPC       string    `json:"rpc"`
+	Caller    string    `json:"caller,omitempty"`
+	Release   string    `json:"release"`
+	Namespace string    `json:"namespace"`
+	Revision  int32     `json:"revision"`
+	Chart     string    `json:"chart,omitempty"`
+	// Digests of the release manifest before and after the RPC, empty when there is no manifest
+	ManifestBefore string         `json:"manifestBefore,omitempty"`
+	ManifestAfter  string         `json:"manifestAfter,omitempty"`
+	Clusters       []clusterAudit `json:"clusters"`
+	Result         string         `json:"result"`
+	Error          string         `json:"error,omitempty"`
+	Duration       float64        `json:"duration"`
+
+	mu sync.Mutex
+}
+
+// clusterAudit is the audit trail of a single operation in a cluster
+type clusterAudit struct {
+	Cluster   string `json:"cluster"`
+	Host      string `json:"host,omitempty"`
+	Operation string `json:"operation"`
+	// Objects are Kind/name of objects the operation created, changed or deleted
+	Objects  []string `json:"objects"`
+	Result   string   `json:"result"`
+	Error    string   `json:"error,omitempty"`
+	Duration float64  `json:"duration"`
+}
+
+const (
+	auditSucceeded = "succeeded"
+	auditFailed    = "failed"
+)
+
+// auditSink stores audit records, it has to be safe to use from concurrent RPCs
+type auditSink interface {
+	Write(record *auditRecord) error
+	io.Closer
+}
+
+// auditSinks create sinks by name from their target, new kinds of sinks are plugged in here
+var auditSinks = map[string]func(target string) (auditSink, error){
+	"file": newFileAuditSink,
+}
+
+// auditor stores audit records of all RPCs, nil when audit is off
+var auditor auditSink
+
+// setupAudit sets auditor to sink with target, "none" turns audit off
+func setupAudit(sink, target string) error {
+	if sink == "" || sink == "none" {
+		return nil
+	}
+	create, ok := auditSinks[sink]
+	if !ok {
+		names := []string{"none"}
+		for name := range auditSinks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown audit sink %q, use %s", sink, strings.Join(names, ", "))
+	}
+	s, err := create(target)
+	if err != nil {
+		return err
+	}
+	auditor = s
+	return nil
+}
+
+// newAuditRecord starts audit record of rpc changing release from before to after, either of which can be nil
+func newAuditRecord(ctx context.Context, rpc string, before, after *releaseAPI.Release) *auditRecord {
+	record := &auditRecord{
+		Time:           time.Now().UTC(),
+		RPC:            rpc,
+		Caller:         caller(ctx),
+		ManifestBefore: manifestDigest(before),
+		ManifestAfter:  manifestDigest(after),
+		Clusters:       []clusterAudit{},
+	}
+	release := after
+	if release == nil {
+		release = before
+	}
+	if release != nil {
+		record.Release, record.Namespace, record.Revision = release.Name, release.Namespace, release.Version
+		if release.Chart != nil && release.Chart.Metadata != nil {
+			record.Chart = release.Chart.Metadata.Name + "-" + release.Chart.Metadata.Version
+		}
+	}
+	return record
+}
+
+// addCluster adds outcome of an operation in a cluster, operations in clusters run concurrently
+func (r *auditRecord) addCluster(cluster clusterAudit, err error) {
+	cluster.Result, cluster.Error = result(err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Clusters = append(r.Clusters, cluster)
+}
+
+// finish sets outcome of the whole RPC
+func (r *auditRecord) finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Result, r.Error = result(err)
+	r.Duration = time.Since(r.Time).Seconds()
+}
+
+func result(err error) (string, string) {
+	if err != nil {
+		return auditFailed, err.Error()
+	}
+	return auditSucceeded, ""
+}
+
+// manifestDigest returns SHA-256 digest of manifest of release
+func manifestDigest(release *releaseAPI.Release) string {
+	if release == nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(release.Manifest))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// caller identifies the client of the RPC by the subject of its certificate, when it has one, and its address
+func caller(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+		return info.State.PeerCertificates[0].Subject.CommonName + "@" + p.Addr.String()
+	}
+	return p.Addr.String()
+}
+
+// fileAuditSink appends audit records to a file as JSON, one record per line
+type fileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// newFileAuditSink appends records to file at path, "-" stands for stdout
+func newFileAuditSink(path string) (auditSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file audit sink needs a target file, - for stdout")
+	}
+	if path == "-" {
+		return &fileAuditSink{file: os.Stdout}, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAuditSink{file: file}, nil
+}
+
+func (s *fileAuditSink) Write(record *auditRecord) error {
+	record.mu.Lock()
+	line, err := json.Marshal(record)
+	record.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil || s.file == os.Stdout {
+		return err
+	}
+	//Audit records are worth the cost of reaching the disk before the RPC returns
+	return s.file.Sync()
+}
+
+// Close closes the file
+func (s *fileAuditSink) Close() error {
+	if s.file == os.Stdout {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	releaseAPI "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// emptyDigest is SHA-256 of an empty manifest
+const emptyDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func auditRelease(version int32, manifest string) *releaseAPI.Release {
+	return &releaseAPI.Release{
+		Name:      "wp4",
+		Namespace: "blog",
+		Version:   version,
+		Manifest:  manifest,
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "wordpress", Version: "0.6.0"}},
+	}
+}
+
+func TestNewAuditRecord(t *testing.T) {
+	before, after := auditRelease(1, "kind: ConfigMap"), auditRelease(2, "")
+
+	record := newAuditRecord(context.Background(), "upgrade", before, after)
+	if record.RPC != "upgrade" || record.Release != "wp4" || record.Namespace != "blog" || record.Revision != 2 {
+		t.Errorf("Expected upgrade of wp4 in blog to revision 2, got %+v", record)
+	}
+	if record.Chart != "wordpress-0.6.0" {
+		t.Errorf("Expected chart wordpress-0.6.0, got %q", record.Chart)
+	}
+	if record.ManifestAfter != emptyDigest || !strings.HasPrefix(record.ManifestBefore, "sha256:") || record.ManifestBefore == emptyDigest {
+		t.Errorf("Expected digests of both manifests, got %q and %q", record.ManifestBefore, record.ManifestAfter)
+	}
+	if record.Caller != "" {
+		t.Errorf("Expected no caller without a peer, got %q", record.Caller)
+	}
+
+	//Delete has no release after it, the record describes the deleted one
+	record = newAuditRecord(context.Background(), "delete", before, nil)
+	if record.Revision != 1 || record.ManifestAfter != "" || record.ManifestBefore == "" {
+		t.Errorf("Expected record of deleted revision 1 without manifest after, got %+v", record)
+	}
+}
+
+func TestAuditRecordOutcome(t *testing.T) {
+	record := newAuditRecord(context.Background(), "install", nil, auditRelease(1, ""))
+	record.addCluster(clusterAudit{Cluster: "cluster-a", Operation: "install", Objects: []string{"StatefulSet/wp4-mariadb"}}, nil)
+	record.addCluster(clusterAudit{Cluster: "cluster-b", Operation: "install"}, errors.New("timed out"))
+	record.finish(errors.New("cluster-b: timed out"))
+
+	if record.Result != auditFailed || record.Error != "cluster-b: timed out" {
+		t.Errorf("Expected failed install, got %s: %s", record.Result, record.Error)
+	}
+	if len(record.Clusters) != 2 || record.Clusters[0].Result != auditSucceeded || record.Clusters[1].Result != auditFailed ||
+		record.Clusters[1].Error != "timed out" {
+		t.Errorf("Expected install succeeded in cluster-a and failed in cluster-b, got %+v", record.Clusters)
+	}
+}
+
+func TestCaller(t *testing.T) {
+	if c := caller(context.Background()); c != "" {
+		t.Errorf("Expected no caller without a peer, got %q", c)
+	}
+
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 44134}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+	if c := caller(ctx); c != "10.0.0.1:44134" {
+		t.Errorf("Expected address of the peer, got %q", c)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "tiller"}}
+	tlsInfo := credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}}
+	ctx = peer.NewContext(context.Background(), &peer.Peer{Addr: addr, AuthInfo: tlsInfo})
+	if c := caller(ctx); c != "tiller@10.0.0.1:44134" {
+		t.Errorf("Expected subject of the client certificate and address of the peer, got %q", c)
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rudder-audit")
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	sink, err := newFileAuditSink(path)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	for _, rpc := range []string{"install", "delete"} {
+		record := newAuditRecord(context.Background(), rpc, nil, auditRelease(1, ""))
+		record.addCluster(clusterAudit{Cluster: "cluster-a", Operation: rpc, Objects: []string{"StatefulSet/wp4-mariadb"}}, nil)
+		record.finish(nil)
+		if err := sink.Write(record); err != nil {
+			t.Fatalf("Expected no errors, got %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected one line per record, got %q", content)
+	}
+	for i, rpc := range []string{"install", "delete"} {
+		line := struct {
+			RPC      string `json:"rpc"`
+			Release  string `json:"release"`
+			Result   string `json:"result"`
+			Clusters []struct {
+				Cluster string   `json:"cluster"`
+				Objects []string `json:"objects"`
+			} `json:"clusters"`
+		}{}
+		if err := json.Unmarshal([]byte(lines[i]), &line); err != nil {
+			t.Fatalf("Expected line %d to be a JSON record, got %v", i, err)
+		}
+		if line.RPC != rpc || line.Release != "wp4" || line.Result != auditSucceeded {
+			t.Errorf("Expected succeeded %s of wp4 on line %d, got %+v", rpc, i, line)
+		}
+		if len(line.Clusters) != 1 || !reflect.DeepEqual(line.Clusters[0].Objects, []string{"StatefulSet/wp4-mariadb"}) {
+			t.Errorf("Expected objects of cluster-a on line %d, got %+v", i, line.Clusters)
+		}
+	}
+}
+
+func TestSetupAuditUnknownSink(t *testing.T) {
+	err := setupAudit("syslog", "")
+	if err == nil || !strings.Contains(err.Error(), "file, none") {
+		t.Errorf("Expected error listing known sinks, got %v", err)
+	}
+	if auditor != nil {
+		t.Errorf("Expected audit to stay off")
+	}
+}
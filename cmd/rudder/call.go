@@ -0,0 +1,129 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	"k8s.io/client-go/tools/record"
+
+	releaseAPI "k8s.io/helm/pkg/proto/hapi/release"
+
+	fedlocal "github.com/kubernetes-helm/rudder-federation/pkg/federation"
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+	"github.com/kubernetes-helm/rudder-federation/pkg/metrics"
+	"github.com/kubernetes-helm/rudder-federation/pkg/tracing"
+)
+
+// recorder sends Kubernetes Events about releases, nil when events can't be sent
+var recorder record.EventRecorder
+
+// call is a single RPC on a release together with everything its steps report to
+type call struct {
+	ctx     context.Context
+	rpc     string
+	release *releaseAPI.Release
+	log     *logrus.Entry
+	// events and audit are recorded only for RPCs which change the release, see start
+	events *fedlocal.ReleaseEvents
+	audit  *auditRecord
+}
+
+func newCall(ctx context.Context, rpc string, release *releaseAPI.Release) *call {
+	return &call{ctx: ctx, rpc: rpc, release: release, log: logging.ForRelease(rpc, release)}
+}
+
+// start records start of an RPC which changes the release from before to after, either of which can be nil
+func (c *call) start(before, after *releaseAPI.Release) {
+	events, err := fedlocal.NewReleaseState(clientset, c.release.Name).Events(recorder)
+	if err != nil {
+		c.log.Warningf("Cannot record events: %v", err)
+	}
+	c.events = events
+	c.events.Started(c.rpc, c.release.Version)
+
+	if auditor != nil {
+		c.audit = newAuditRecord(c.ctx, c.rpc, before, after)
+	}
+}
+
+// finish records final outcome of the RPC
+func (c *call) finish(err error) {
+	c.events.Finished(c.rpc, c.release.Version, err)
+
+	if c.audit != nil {
+		c.audit.finish(err)
+		if err := auditor.Write(c.audit); err != nil {
+			c.log.Errorf("Cannot write audit record: %v", err)
+		}
+	}
+}
+
+// track runs operation of update in its cluster in a span of its own, logging and recording its duration and outcome,
+// and keeps it in flight while it runs, so shutdown waits for it
+func (c *call) track(u clusterUpdate, operation string, op func() error) error {
+	cluster := u.cluster
+	done := running.start(cluster.Name, operation)
+	defer done()
+
+	span, _ := tracing.StartSpan(c.ctx, operation)
+	span.SetTag(logging.ClusterField, cluster.Name)
+	span.SetTag(logging.HostField, cluster.Host)
+
+	log := logging.ForCluster(c.log, cluster.Name, cluster.Host).WithField("operation", operation)
+	log.Info("Started")
+	start := time.Now()
+	err := op()
+	metrics.ObserveClusterOperation(cluster.Name, operation, start, err)
+	tracing.Finish(span, err)
+	c.events.ClusterFinished(operation, cluster.Name, err)
+	if c.audit != nil {
+		c.auditCluster(u, operation, time.Since(start), err)
+	}
+
+	log = log.WithField(logging.DurationField, time.Since(start).Seconds())
+	if err != nil {
+		log.Errorf("Failed: %v", err)
+	} else {
+		log.Info("Finished")
+	}
+	return err
+}
+
+// tracked tracks op in every cluster under the operation name
+func (c *call) tracked(operation string, op func(clusterUpdate) error) func(clusterUpdate) error {
+	return func(u clusterUpdate) error {
+		return c.track(u, operation, func() error { return op(u) })
+	}
+}
+
+// auditCluster adds outcome of operation of update to the audit record, with objects of both its manifests
+func (c *call) auditCluster(u clusterUpdate, operation string, duration time.Duration, err error) {
+	objects, parseErr := fedlocal.ObjectsOf(u.current, u.target)
+	if parseErr != nil {
+		c.log.Warningf("Cannot list objects for audit: %v", parseErr)
+	}
+	c.audit.addCluster(clusterAudit{
+		Cluster:   u.cluster.Name,
+		Host:      u.cluster.Host,
+		Operation: operation,
+		Objects:   objects,
+		Duration:  duration.Seconds(),
+	}, err)
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"k8s.io/helm/pkg/storage"
+	"k8s.io/helm/pkg/storage/driver"
+
+	fedlocal "github.com/kubernetes-helm/rudder-federation/pkg/federation"
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+)
+
+// failover makes another member cluster primary for a deployed release. Primary-only objects are created
+// and waited for in the new primary first, which is then recorded as the primary, the annotation of federated Services
+// is updated next and objects are removed from the old primary last. The old primary may have left the federation
+// or be unreachable. Failover records events and audit like RPCs changing the release.
+//
+//	rudder failover --release wp4 --to cluster-b
+func failover(args []string) (err error) {
+	flags := flag.NewFlagSet("failover", flag.ExitOnError)
+	name := flags.String("release", "", "name of the release to fail over")
+	to := flags.String("to", "", "member cluster which becomes the primary one")
+	timeout := flags.Int64("timeout", 300, "seconds to wait for objects to become ready in every cluster")
+	auditSink := flags.String("audit-sink", envOr("RUDDER_AUDIT_SINK", "none"), "where to record audit of the failover: none or file (RUDDER_AUDIT_SINK)")
+	auditFile := flags.String("audit-target", os.Getenv("RUDDER_AUDIT_TARGET"), "file audit records are appended to, - for stdout (RUDDER_AUDIT_TARGET)")
+	flags.Parse(args)
+
+	if *name == "" || *to == "" {
+		return fmt.Errorf("both --release and --to are required")
+	}
+
+	store := storage.Init(driver.NewConfigMaps(clientset.Core().ConfigMaps(fedlocal.RudderNamespace())))
+	release, err := store.Deployed(*name)
+	if err != nil {
+		return fmt.Errorf("cannot find deployed release %s: %v", *name, err)
+	}
+
+	if err := setupAudit(*auditSink, *auditFile); err != nil {
+		return err
+	}
+	if auditor != nil {
+		defer auditor.Close()
+	}
+	if recorder, err = fedlocal.NewEventRecorder(); err != nil {
+		logging.Log.Warningf("Cannot record Kubernetes Events, failover will only be logged: %v", err)
+	}
+
+	c := newCall(context.Background(), "failover", release)
+	log := c.log
+	c.start(release, release)
+	defer func() { c.finish(err) }()
+
+	_, fedClient, clients, err := fedlocal.GetAllClients()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := fedlocal.NewRewriter(clients).Rewrite(release)
+	if err != nil {
+		return err
+	}
+	federated, local, err := fedlocal.SplitManifestForFed(manifest)
+	if err != nil {
+		return err
+	}
+
+	current, err := localRules(release, clients)
+	if err != nil {
+		return err
+	}
+	if len(current.Placement.Policies) == 0 {
+		return fmt.Errorf("release %s has no placement, there is nothing to fail over", *name)
+	}
+	from, err := current.Primary()
+	if err != nil {
+		return err
+	}
+	if from == *to {
+		log.Infof("%s is already the primary cluster", *to)
+		return nil
+	}
+
+	target, err := fedlocal.GetLocalRules(release.Config, clients)
+	if err != nil {
+		return err
+	}
+	target.Placement.Primary = *to
+	ordered, err := target.Placement.Order(clients)
+	if err != nil {
+		return err
+	}
+
+	//The new primary goes first, so that primary-only objects exist somewhere all the time
+	members := []clusterUpdate{}
+	for _, cluster := range ordered {
+		clusterCurrent, err := current.ManifestForCluster(local, cluster)
+		if err != nil {
+			return err
+		}
+		clusterTarget, err := target.ManifestForCluster(local, cluster)
+		if err != nil {
+			return err
+		}
+		members = append(members, clusterUpdate{cluster: cluster, current: clusterCurrent, target: clusterTarget})
+	}
+
+	federatedCurrent, err := current.AnnotatePrimary(federated)
+	if err != nil {
+		return err
+	}
+	federatedTarget, err := target.AnnotatePrimary(federated)
+	if err != nil {
+		return err
+	}
+	fed := clusterUpdate{
+		cluster: fedlocal.FederationCluster(fedClient),
+		current: federatedCurrent,
+		target:  federatedTarget,
+	}
+
+	if current.PrimaryLost() {
+		log.Warningf("Primary cluster %s is not a member of the federation anymore", from)
+	}
+	log.Infof("Failing over from %s to %s", from, *to)
+	apply := func(u clusterUpdate) error {
+		return fedlocal.UpdateWithCRDs(u.cluster.Client, release.Namespace, u.current, u.target, false, false, *timeout, true)
+	}
+	record := func() error {
+		return fedlocal.NewReleaseState(clientset, release.Name).SetActivePrimary(*to)
+	}
+	return failOver(c, members, fed, apply, record)
+}
+
+// failOver applies updates moving primary-only objects, members[0] being the new primary. Once the new primary
+// is updated, it is recorded and federated Services are annotated with it. The old primary may be unreachable or gone
+// for good, so updates of the rest of member clusters only log failures, leaving objects behind.
+func failOver(c *call, members []clusterUpdate, fed clusterUpdate, apply func(clusterUpdate) error, record func() error) error {
+	primary, rest := members[0], members[1:]
+	err := c.track(primary, "failover", func() error { return apply(primary) })
+	if err != nil {
+		return fmt.Errorf("cannot move primary-only objects to %s, the primary cluster is unchanged: %v", primary.cluster.Name, err)
+	}
+	if err := record(); err != nil {
+		return fmt.Errorf("objects are in %s, but it cannot be recorded as the primary cluster: %v", primary.cluster.Name, err)
+	}
+	c.log.Infof("%s is the primary cluster now", primary.cluster.Name)
+
+	if fed.current != fed.target {
+		if err := c.track(fed, "failover", func() error { return apply(fed) }); err != nil {
+			return fmt.Errorf("federated Services are still annotated with the old primary cluster, run helm upgrade to update them: %v", err)
+		}
+	}
+
+	left := clusterErrors{}
+	for _, u := range rest {
+		if u.current == u.target {
+			continue
+		}
+		if err := c.track(u, "failover", func() error { return apply(u) }); err != nil {
+			left[u.cluster] = err
+		}
+	}
+	if len(left) > 0 {
+		c.log.Warningf("Objects of the release may be left behind, delete them by hand once clusters are back: %v", left)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// failoverUpdates returns updates of clusters which all change, the new primary first
+func failoverUpdates(names ...string) []clusterUpdate {
+	updates := testUpdates(names...)
+	for i := range updates {
+		updates[i].current, updates[i].target = "current", "target"
+	}
+	return updates
+}
+
+func TestFailOverWithUnreachableOldPrimary(t *testing.T) {
+	clusters := &fakeClusters{failing: map[string]bool{"a": true}}
+	fed := testFederation()
+	fed.current, fed.target = "current", "target"
+	recorded := false
+	record := func() error {
+		recorded = true
+		return nil
+	}
+
+	//b becomes the primary, a is the old primary which can't be reached
+	err := failOver(newCall(context.Background(), "failover", nil), failoverUpdates("b", "a", "c"), fed, clusters.apply, record)
+	if err != nil {
+		t.Fatalf("Expected failover to succeed without the old primary, got %v", err)
+	}
+	if !recorded {
+		t.Errorf("Expected the new primary to be recorded")
+	}
+	if !reflect.DeepEqual(clusters.applied, []string{"b", "federation", "a", "c"}) {
+		t.Errorf("Expected the new primary, federation and then the rest updated, got %v", clusters.applied)
+	}
+}
+
+func TestFailOverKeepsPrimaryWhenNewOneFails(t *testing.T) {
+	clusters := &fakeClusters{failing: map[string]bool{"b": true}}
+	recorded := false
+	record := func() error {
+		recorded = true
+		return nil
+	}
+
+	err := failOver(newCall(context.Background(), "failover", nil), failoverUpdates("b", "a"), testFederation(), clusters.apply, record)
+	if err == nil {
+		t.Fatalf("Expected failover to fail when the new primary can't be updated")
+	}
+	if recorded || !reflect.DeepEqual(clusters.applied, []string{"b"}) {
+		t.Errorf("Expected nothing but the new primary touched, got %v updated and recorded %v", clusters.applied, recorded)
+	}
+}
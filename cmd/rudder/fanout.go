@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+
+	fedlocal "github.com/kubernetes-helm/rudder-federation/pkg/federation"
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+)
+
+// clusterUpdate is the part of a release update which happens in a single cluster
+type clusterUpdate struct {
+	cluster *fedlocal.Cluster
+	current string
+	target  string
+}
+
+// clusterErrors maps clusters to errors which occurred in them. Clusters are keyed by themselves, not by their names,
+// because nothing stops a member cluster from being called federation.
+type clusterErrors map[*fedlocal.Cluster]error
+
+func (e clusterErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for cluster, err := range e {
+		messages = append(messages, fmt.Sprintf("%s: %v", cluster.Name, err))
+	}
+	sort.Strings(messages)
+	return strings.Join(messages, "; ")
+}
+
+// orNil returns nil error when no cluster failed
+func (e clusterErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// inParallel runs op for every update concurrently and waits for all of them to finish
+func inParallel(updates []clusterUpdate, op func(clusterUpdate) error) clusterErrors {
+	type result struct {
+		cluster *fedlocal.Cluster
+		err     error
+	}
+
+	results := make(chan result, len(updates))
+	for _, u := range updates {
+		go func(u clusterUpdate) {
+			results <- result{u.cluster, op(u)}
+		}(u)
+	}
+
+	errs := clusterErrors{}
+	for range updates {
+		r := <-results
+		if r.err != nil {
+			errs[r.cluster] = r.err
+		}
+	}
+	return errs
+}
+
+// logger returns log with fields of the cluster of update
+func (u clusterUpdate) logger(log *logrus.Entry) *logrus.Entry {
+	return logging.ForCluster(log, u.cluster.Name, u.cluster.Host)
+}
+
+func clusterNames(updates []clusterUpdate) string {
+	names := make([]string, 0, len(updates))
+	for _, u := range updates {
+		names = append(names, u.cluster.Name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdateAtOnceRevertsOnPartialFailure(t *testing.T) {
+	clusters := &fakeClusters{failing: map[string]bool{"b": true}}
+	updates := append(testUpdates("a", "b"), testFederation())
+
+	err := updateAtOnce(testLog, updates, clusters.apply, clusters.revert, true)
+	if err == nil || !strings.Contains(err.Error(), "b: b is down") || !strings.Contains(err.Error(), "reverted") {
+		t.Fatalf("Expected failure in b and revert, got %v", err)
+	}
+	if !reflect.DeepEqual(sortedNames(clusters.applied), []string{"a", "b", "federation"}) {
+		t.Errorf("Expected every cluster updated, got %v", clusters.applied)
+	}
+	//b failed half way through and has to be reverted as well
+	if !reflect.DeepEqual(sortedNames(clusters.reverted), []string{"a", "b", "federation"}) {
+		t.Errorf("Expected every cluster reverted, got %v", clusters.reverted)
+	}
+}
+
+func TestUpdateAtOnceWithoutRollback(t *testing.T) {
+	clusters := &fakeClusters{failing: map[string]bool{"b": true}}
+
+	err := updateAtOnce(testLog, testUpdates("a", "b"), clusters.apply, clusters.revert, false)
+	if err == nil || err.Error() != "b: b is down" {
+		t.Fatalf("Expected failure in b, got %v", err)
+	}
+	if len(clusters.reverted) != 0 {
+		t.Errorf("Expected nothing reverted, got %v", clusters.reverted)
+	}
+}
+
+func TestInParallelKeepsClustersNamedLikeFederation(t *testing.T) {
+	clusters := &fakeClusters{failing: map[string]bool{"federation": true}}
+	//A member cluster called federation next to the federation itself
+	updates := append(testUpdates("federation"), testFederation())
+
+	errs := inParallel(updates, clusters.apply)
+	if len(errs) != 2 {
+		t.Errorf("Expected errors of both clusters, got %v", errs)
+	}
+	if errs.Error() != "federation: federation is down; federation: federation is down" {
+		t.Errorf("Expected both errors listed, got %q", errs.Error())
+	}
+}
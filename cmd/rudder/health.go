@@ -0,0 +1,81 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	fedlocal "github.com/kubernetes-helm/rudder-federation/pkg/federation"
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+)
+
+// healthCheckPeriod is how often federation connectivity is checked for the gRPC health service
+var healthCheckPeriod = 10 * time.Second
+
+// checkFederation tells if rudder can work with the federation
+var checkFederation = fedlocal.CheckFederation
+
+// serveHealth registers gRPC health service on server and keeps its status in line with federation connectivity
+func serveHealth(server *grpc.Server) {
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	go func() {
+		var last error
+		for {
+			last = updateHealth(healthServer, last)
+			time.Sleep(healthCheckPeriod)
+		}
+	}()
+}
+
+// updateHealth checks the federation and sets serving status of healthServer accordingly. Only changes of the outcome
+// since the last check are logged, the check runs all the time. Returns outcome of the check.
+func updateHealth(healthServer *health.Server, last error) error {
+	err := checkFederation()
+	status := healthpb.HealthCheckResponse_SERVING
+	if err != nil {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	if err != nil && (last == nil || err.Error() != last.Error()) {
+		logging.Log.Warningf("Not ready: %v", err)
+	} else if err == nil && last != nil {
+		logging.Log.Info("Ready")
+	}
+
+	healthServer.SetServingStatus("", status)
+	return err
+}
+
+// healthz tells rudder is alive, it doesn't depend on federation, so rudder isn't restarted when federation is down
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// readyz tells rudder can serve releases, which needs federation credentials and a reachable federation
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if err := checkFederation(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var errUnreachable = errors.New("federation API server https://federation.example.com is not reachable: connection refused")
+
+// withFederation makes health checks see federation failing with err, nil for a healthy one
+func withFederation(err error) func() {
+	saved := checkFederation
+	checkFederation = func() error { return err }
+	return func() { checkFederation = saved }
+}
+
+func probe(handler http.HandlerFunc) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder
+}
+
+func TestProbesWithUnreachableFederation(t *testing.T) {
+	defer withFederation(errUnreachable)()
+
+	//Rudder is alive even when federation is down, so it isn't restarted
+	if r := probe(healthz); r.Code != http.StatusOK {
+		t.Errorf("Expected healthz to be OK, got %d: %s", r.Code, r.Body.String())
+	}
+	if r := probe(readyz); r.Code != http.StatusServiceUnavailable || !strings.Contains(r.Body.String(), "not reachable") {
+		t.Errorf("Expected readyz to be unavailable with the reason, got %d: %s", r.Code, r.Body.String())
+	}
+}
+
+func TestProbesWithReachableFederation(t *testing.T) {
+	defer withFederation(nil)()
+
+	for name, handler := range map[string]http.HandlerFunc{"healthz": healthz, "readyz": readyz} {
+		if r := probe(handler); r.Code != http.StatusOK || r.Body.String() != "ok" {
+			t.Errorf("Expected %s to be OK, got %d: %s", name, r.Code, r.Body.String())
+		}
+	}
+}
+
+func servingStatus(t *testing.T, healthServer *health.Server) healthpb.HealthCheckResponse_ServingStatus {
+	response, err := healthServer.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	return response.Status
+}
+
+func TestUpdateHealthFlipsServingStatus(t *testing.T) {
+	healthServer := health.NewServer()
+
+	restore := withFederation(nil)
+	last := updateHealth(healthServer, nil)
+	restore()
+	if last != nil || servingStatus(t, healthServer) != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected serving with reachable federation, got %v", servingStatus(t, healthServer))
+	}
+
+	restore = withFederation(errUnreachable)
+	last = updateHealth(healthServer, last)
+	restore()
+	if last != errUnreachable || servingStatus(t, healthServer) != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Expected not serving with unreachable federation, got %v", servingStatus(t, healthServer))
+	}
+
+	restore = withFederation(nil)
+	last = updateHealth(healthServer, last)
+	restore()
+	if last != nil || servingStatus(t, healthServer) != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected serving again once federation is back, got %v", servingStatus(t, healthServer))
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	fedlocal "github.com/kubernetes-helm/rudder-federation/pkg/federation"
+)
+
+// rollOut applies member updates in waves planned by rollout and the federation update after the last wave.
+// Federation update stages nothing, checkRollout makes sure it changes no federated object.
+// Each wave has to succeed before the next one starts. If any wave fails, or the pause after a wave is cut short
+// because ctx is done or rudder is shutting down, every cluster updated so far is reverted.
+func rollOut(ctx context.Context, log *logrus.Entry, rollout *fedlocal.Rollout, fed clusterUpdate, members []clusterUpdate, apply, revert func(clusterUpdate) error) error {
+	clusters := make([]*fedlocal.Cluster, 0, len(members))
+	byName := map[string]clusterUpdate{}
+	for _, u := range members {
+		clusters = append(clusters, u.cluster)
+		byName[u.cluster.Name] = u
+	}
+
+	planned, err := rollout.Waves(clusters)
+	if err != nil {
+		return err
+	}
+	pause, err := rollout.PauseDuration()
+	if err != nil {
+		return err
+	}
+
+	waves := make([][]clusterUpdate, 0, len(planned)+1)
+	for _, p := range planned {
+		wave := make([]clusterUpdate, 0, len(p))
+		for _, c := range p {
+			wave = append(wave, byName[c.Name])
+		}
+		waves = append(waves, wave)
+	}
+	waves = append(waves, []clusterUpdate{fed})
+
+	updated := []clusterUpdate{}
+	for i, wave := range waves {
+		log.Infof("Rolling out wave %d/%d to %s", i+1, len(waves), clusterNames(wave))
+		errs := inParallel(wave, apply)
+		updated = append(updated, wave...)
+
+		if len(errs) > 0 {
+			return revertUpdated(log, fmt.Errorf("rollout wave %d failed: %v", i+1, errs), updated, revert)
+		}
+
+		if pause > 0 && i < len(waves)-1 {
+			log.Infof("Wave %d done, pausing for %v", i+1, pause)
+			if err := pauseRollout(ctx, pause); err != nil {
+				return revertUpdated(log, fmt.Errorf("rollout stopped after wave %d: %v", i+1, err), updated, revert)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkRollout refuses rollouts which change federated objects, because federation propagates them to every
+// cluster at once and there is no way to stage them in waves
+func checkRollout(fed clusterUpdate) error {
+	changed, err := fedlocal.ChangedObjects(fed.current, fed.target)
+	if err != nil {
+		return err
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("rollout can't stage federated objects %v, federation updates them in all clusters at once; upgrade them without rollout", changed)
+	}
+	return nil
+}
+
+// errShuttingDown stops rollouts which would outlive the shutdown grace period
+var errShuttingDown = errors.New("rudder is shutting down")
+
+// pauseRollout waits for pause, unless ctx is done or rudder starts shutting down first
+func pauseRollout(ctx context.Context, pause time.Duration) error {
+	timer := time.NewTimer(pause)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-shuttingDown:
+		return errShuttingDown
+	}
+}
+
+// revertUpdated reverts clusters which were already updated when failure happened.
+// Returned error describes both the original failure and outcome of reverting.
+func revertUpdated(log *logrus.Entry, failure error, updated []clusterUpdate, revert func(clusterUpdate) error) error {
+	if len(updated) == 0 {
+		return failure
+	}
+
+	log.Warningf("Reverting %s after failure: %v", clusterNames(updated), failure)
+	if errs := inParallel(updated, revert); len(errs) > 0 {
+		return fmt.Errorf("%v; reverting failed: %v", failure, errs)
+	}
+	return fmt.Errorf("%v; reverted %s", failure, clusterNames(updated))
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	fedlocal "github.com/kubernetes-helm/rudder-federation/pkg/federation"
+)
+
+var testLog = logrus.NewEntry(logrus.New())
+
+// fakeClusters records updates applied and reverted in clusters, failing to apply them in failing clusters
+type fakeClusters struct {
+	mu       sync.Mutex
+	failing  map[string]bool
+	applied  []string
+	reverted []string
+}
+
+func (f *fakeClusters) apply(u clusterUpdate) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.applied = append(f.applied, u.cluster.Name)
+	if f.failing[u.cluster.Name] {
+		return fmt.Errorf("%s is down", u.cluster.Name)
+	}
+	return nil
+}
+
+func (f *fakeClusters) revert(u clusterUpdate) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reverted = append(f.reverted, u.cluster.Name)
+	return nil
+}
+
+func testUpdates(names ...string) []clusterUpdate {
+	updates := make([]clusterUpdate, len(names))
+	for i, name := range names {
+		updates[i] = clusterUpdate{cluster: &fedlocal.Cluster{Name: name}}
+	}
+	return updates
+}
+
+func testFederation() clusterUpdate {
+	return clusterUpdate{cluster: &fedlocal.Cluster{Name: "federation"}}
+}
+
+func sortedNames(names []string) []string {
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func TestRollOutWaves(t *testing.T) {
+	clusters := &fakeClusters{}
+	rollout := &fedlocal.Rollout{Canary: []string{"b"}, WavePercent: 50}
+
+	err := rollOut(context.Background(), testLog, rollout, testFederation(), testUpdates("a", "b", "c", "d"), clusters.apply, clusters.revert)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	//Waves are [b], [a c], [d] and federation goes last, clusters within a wave are updated in any order
+	if len(clusters.applied) != 5 {
+		t.Fatalf("Expected 5 updates, got %v", clusters.applied)
+	}
+	if clusters.applied[0] != "b" || !reflect.DeepEqual(sortedNames(clusters.applied[1:3]), []string{"a", "c"}) ||
+		clusters.applied[3] != "d" || clusters.applied[4] != "federation" {
+		t.Errorf("Expected waves b, a c, d and federation last, got %v", clusters.applied)
+	}
+	if len(clusters.reverted) != 0 {
+		t.Errorf("Expected nothing to be reverted, got %v", clusters.reverted)
+	}
+}
+
+func TestRollOutRevertsOnFailure(t *testing.T) {
+	clusters := &fakeClusters{failing: map[string]bool{"c": true}}
+	rollout := &fedlocal.Rollout{Canary: []string{"b"}, WavePercent: 50}
+
+	err := rollOut(context.Background(), testLog, rollout, testFederation(), testUpdates("a", "b", "c", "d"), clusters.apply, clusters.revert)
+	if err == nil || !strings.Contains(err.Error(), "rollout wave 2 failed") {
+		t.Fatalf("Expected failure of wave 2, got %v", err)
+	}
+
+	if !reflect.DeepEqual(sortedNames(clusters.applied), []string{"a", "b", "c"}) {
+		t.Errorf("Expected no wave after the failed one, got %v", clusters.applied)
+	}
+	//The failed wave is reverted too, as its clusters may be updated partially
+	if !reflect.DeepEqual(sortedNames(clusters.reverted), []string{"a", "b", "c"}) {
+		t.Errorf("Expected clusters of both waves reverted, got %v", clusters.reverted)
+	}
+}
+
+func TestRollOutPauseStopsWhenDone(t *testing.T) {
+	clusters := &fakeClusters{}
+	rollout := &fedlocal.Rollout{Canary: []string{"b"}, Pause: "1h"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := rollOut(ctx, testLog, rollout, testFederation(), testUpdates("a", "b"), clusters.apply, clusters.revert)
+	if err == nil || !strings.Contains(err.Error(), "rollout stopped after wave 1") {
+		t.Fatalf("Expected rollout to stop during the pause, got %v", err)
+	}
+	if !reflect.DeepEqual(clusters.applied, []string{"b"}) || !reflect.DeepEqual(clusters.reverted, []string{"b"}) {
+		t.Errorf("Expected the canary updated and reverted, got %v updated and %v reverted", clusters.applied, clusters.reverted)
+	}
+}
+
+func TestCheckRolloutRejectsFederatedChanges(t *testing.T) {
+	fed := testFederation()
+	fed.current = "---\nkind: Deployment\nmetadata:\n  name: wp\nspec:\n  replicas: 1\n"
+	fed.target = fed.current
+	if err := checkRollout(fed); err != nil {
+		t.Errorf("Expected rollout without federated changes to pass, got %v", err)
+	}
+
+	fed.target = "---\nkind: Deployment\nmetadata:\n  name: wp\nspec:\n  replicas: 2\n"
+	err := checkRollout(fed)
+	if err == nil || !strings.Contains(err.Error(), "Deployment/wp") {
+		t.Errorf("Expected error naming the changed federated Deployment, got %v", err)
+	}
+}
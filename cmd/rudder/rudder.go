@@ -18,47 +18,121 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"flag"
 	"fmt"
 	"net"
+	"net/http"
+	"os"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/grpclog"
 	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
 
+	"k8s.io/helm/pkg/hooks"
 	"k8s.io/helm/pkg/kube"
 	releaseAPI "k8s.io/helm/pkg/proto/hapi/release"
 	rudderAPI "k8s.io/helm/pkg/proto/hapi/rudder"
-	"k8s.io/helm/pkg/rudder"
 	"k8s.io/helm/pkg/tiller"
 	"k8s.io/helm/pkg/version"
 
 	fedlocal "github.com/kubernetes-helm/rudder-federation/pkg/federation"
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+	"github.com/kubernetes-helm/rudder-federation/pkg/metrics"
+	"github.com/kubernetes-helm/rudder-federation/pkg/policy"
+	"github.com/kubernetes-helm/rudder-federation/pkg/tracing"
 )
 
 var kubeClient *kube.Client
 var clientset internalclientset.Interface
 
-var grpcAddr = fmt.Sprintf("127.0.0.1:%d", rudder.GrpcPort)
+// defaultTimeout is how long objects and hooks of installs and deletes are waited for, in seconds.
+// Only upgrade and rollback requests carry a timeout given to helm.
+const defaultTimeout = 500
+
+var httpAddr = flag.String("http-address", ":10002", "address to serve Prometheus metrics and health probes on")
 
 func main() {
 	var err error
 	kubeClient = kube.New(nil)
 	clientset, err = kubeClient.ClientSet()
 	if err != nil {
-		grpclog.Fatalf("Cannot initialize Kubernetes connection: %s", err)
+		logging.Log.Fatalf("Cannot initialize Kubernetes connection: %s", err)
 	}
 
-	lis, err := net.Listen("tcp", grpcAddr)
+	if len(os.Args) > 1 && os.Args[1] == "failover" {
+		if err := failover(os.Args[2:]); err != nil {
+			logging.Log.Fatalf("Failover failed: %v", err)
+		}
+		return
+	}
+	flag.Parse()
+	if err := logging.Configure(*logLevel, *logFormat); err != nil {
+		logging.Log.Fatalf("Cannot configure logging: %v", err)
+	}
+	if recorder, err = fedlocal.NewEventRecorder(); err != nil {
+		logging.Log.Warningf("Cannot record Kubernetes Events, releases will only be logged: %v", err)
+	}
+
+	tracer, err := tracing.Setup(*traceExporter, *traceTarget)
 	if err != nil {
-		grpclog.Fatalf("failed to listen: %v", err)
+		logging.Log.Fatalf("Cannot set up tracing: %v", err)
+	}
+	defer tracer.Close()
+
+	if err := setupAudit(*auditSinkName, *auditTarget); err != nil {
+		logging.Log.Fatalf("Cannot set up audit: %v", err)
+	}
+	if auditor != nil {
+		defer auditor.Close()
 	}
-	grpcServer := grpc.NewServer()
-	rudderAPI.RegisterReleaseModuleServiceServer(grpcServer, &ReleaseModuleServiceServer{})
 
-	grpclog.Info("Federation Rudder started")
-	grpcServer.Serve(lis)
+	options := []grpc.ServerOption{
+		grpc.UnaryInterceptor(chainUnary(tracing.UnaryServerInterceptor, metrics.UnaryServerInterceptor)),
+		grpc.StreamInterceptor(metrics.StreamServerInterceptor),
+	}
+	creds, err := serverCredentials(*tlsCert, *tlsKey, *tlsClientCA)
+	if err != nil {
+		logging.Log.Fatalf("Cannot set up TLS: %v", err)
+	}
+	if creds != nil {
+		options = append(options, grpc.Creds(creds))
+	}
+
+	lis, err := net.Listen("tcp", grpcAddr())
+	if err != nil {
+		logging.Log.Fatalf("failed to listen: %v", err)
+	}
+	grpcServer := grpc.NewServer(options...)
+	rudderAPI.RegisterReleaseModuleServiceServer(grpcServer, &ReleaseModuleServiceServer{})
+	metrics.Register(grpcServer)
+	serveHealth(grpcServer)
+
+	http.Handle("/metrics", metrics.Handler())
+	http.HandleFunc("/healthz", healthz)
+	http.HandleFunc("/readyz", readyz)
+	go func() {
+		logging.Log.Infof("Serving metrics and health probes on %s", *httpAddr)
+		if err := http.ListenAndServe(*httpAddr, nil); err != nil {
+			logging.Log.Fatalf("failed to serve metrics and health probes: %v", err)
+		}
+	}()
+
+	logging.Log.Infof("Federation Rudder started on %s (TLS: %v, client certificates: %v)", grpcAddr(), creds != nil, *tlsClientCA != "")
+	stopped := make(chan struct{})
+	go func() {
+		stopOnSignal(grpcServer, *gracePeriod)
+		close(stopped)
+	}()
+	if err := serveUntilStopped(func() error { return grpcServer.Serve(lis) }, stopped); err != nil {
+		logging.Log.Fatalf("failed to serve: %v", err)
+	}
+	logging.Log.Info("Federation Rudder stopped")
 }
 
 // ReleaseModuleServiceServer provides implementation for rudderAPI.ReleaseModuleServiceServer
@@ -66,7 +140,8 @@ type ReleaseModuleServiceServer struct{}
 
 // Version is not yet implemented
 func (r *ReleaseModuleServiceServer) Version(ctx context.Context, in *rudderAPI.VersionReleaseRequest) (*rudderAPI.VersionReleaseResponse, error) {
-	grpclog.Info("version")
+	log := logging.ForRelease("version", nil)
+	log.Info("Version requested")
 	return &rudderAPI.VersionReleaseResponse{
 		Name:    "helm-rudder-native",
 		Version: version.Version,
@@ -74,271 +149,772 @@ func (r *ReleaseModuleServiceServer) Version(ctx context.Context, in *rudderAPI.
 }
 
 // InstallRelease creates a release in federation and federated clusters
-func (r *ReleaseModuleServiceServer) InstallRelease(ctx context.Context, in *rudderAPI.InstallReleaseRequest) (*rudderAPI.InstallReleaseResponse, error) {
-	grpclog.Info("install")
+func (r *ReleaseModuleServiceServer) InstallRelease(ctx context.Context, in *rudderAPI.InstallReleaseRequest) (_ *rudderAPI.InstallReleaseResponse, err error) {
+	c := newCall(ctx, "install", in.Release)
+	log := c.log
+	log.Info("Installing")
+	c.start(nil, in.Release)
+	defer func() { c.finish(err) }()
+
+	if err := fedlocal.ValidateRelease(in.Release); err != nil {
+		log.Errorf("Error validating release: %v", err)
+		return &rudderAPI.InstallReleaseResponse{}, err
+	}
 
-	manifest := in.Release.Manifest
-	replacements := fedlocal.GetReplacements(in)
+	_, fedClient, clients, err := fedlocal.GetAllClients()
 
-	if len(replacements) > 0 {
-		fedController, err := fedlocal.GetFederationControllerDeployment(in)
-		if err != nil {
-			grpclog.Infof("error getting federation controller")
-			return &rudderAPI.InstallReleaseResponse{}, err
-		}
-		manifest, err = fedlocal.ReplaceWithFederationDeployment(manifest, replacements, fedController)
-		if err != nil {
-			grpclog.Infof("error replacing replacements")
-			return &rudderAPI.InstallReleaseResponse{}, err
-		}
+	if err != nil {
+		log.Errorf("Error getting clients: %v", err)
+		return &rudderAPI.InstallReleaseResponse{}, err
 	}
 
-	federated, local, err := fedlocal.SplitManifestForFed(manifest)
+	span, _ := tracing.StartSpan(ctx, "rewrite")
+	manifest, err := fedlocal.NewRewriter(clients).Rewrite(in.Release)
+	tracing.Finish(span, err)
+	if err != nil {
+		log.Errorf("Error rewriting manifest: %v", err)
+		return &rudderAPI.InstallReleaseResponse{}, err
+	}
 
+	releaseHooks, err := hooksOf(log, in.Release)
 	if err != nil {
-		grpclog.Infof("error splitting manifests: %v", err)
+		log.Errorf("Error reading hooks: %v", err)
 		return &rudderAPI.InstallReleaseResponse{}, err
 	}
 
-	_, _, clients, err := fedlocal.GetAllClients()
+	locals, err := localRules(in.Release, clients)
+	if err != nil {
+		log.Errorf("Error reading placement and cluster overrides: %v", err)
+		return &rudderAPI.InstallReleaseResponse{}, err
+	}
+
+	span, _ = tracing.StartSpan(ctx, "split")
+	federated, local, err := fedlocal.SplitManifestForFed(manifest)
+	tracing.Finish(span, err)
+
+	if err != nil {
+		log.Errorf("Error splitting manifests: %v", err)
+		return &rudderAPI.InstallReleaseResponse{}, err
+	}
 
+	federated, err = locals.AnnotatePrimary(federated)
 	if err != nil {
-		grpclog.Infof("error getting clients: %v", err)
+		log.Errorf("Error annotating federated services: %v", err)
+		return &rudderAPI.InstallReleaseResponse{}, err
+	}
+	observeSplit(log, federated, local)
+
+	installs := make([]clusterUpdate, 0, len(clients))
+	for _, cluster := range clients {
+		clusterManifest, err := locals.ManifestForCluster(local, cluster)
+		if err != nil {
+			logging.ForCluster(log, cluster.Name, cluster.Host).Errorf("Error placing local objects: %v", err)
+			return &rudderAPI.InstallReleaseResponse{}, err
+		}
+		installs = append(installs, clusterUpdate{cluster: cluster, target: clusterManifest})
+	}
+
+	runner := &fedlocal.HookRunner{Federation: fedClient, Locals: locals, Namespace: in.Release.Namespace, Timeout: defaultTimeout, Log: log}
+	if err := checkPolicy(federated, installs, runner, releaseHooks, hooks.PreInstall, hooks.PostInstall); err != nil {
+		log.Errorf("Error checking policy: %v", err)
+		return &rudderAPI.InstallReleaseResponse{}, err
+	}
+	if err := checkClusterScoped(in.Release, installs, runner, releaseHooks, hooks.PreInstall, hooks.PostInstall); err != nil {
+		log.Errorf("Error checking cluster-scoped objects: %v", err)
+		return &rudderAPI.InstallReleaseResponse{}, err
+	}
+
+	if err := runner.Run(releaseHooks, hooks.PreInstall); err != nil {
+		log.Errorf("Error running hooks: %v", err)
 		return &rudderAPI.InstallReleaseResponse{}, err
 	}
 
-	err = fedlocal.CreateInFederation(federated, in)
+	fed := clusterUpdate{cluster: fedlocal.FederationCluster(fedClient), target: federated}
+	err = c.track(fed, "install", func() error {
+		return fedlocal.CreateInFederation(federated, in)
+	})
 	if err != nil {
-		grpclog.Infof("error creating federated objects: %v", err)
+		log.Errorf("Error creating federated objects: %v", err)
 		return &rudderAPI.InstallReleaseResponse{}, err
 	}
 
-	for _, c := range clients {
-		config, _ := c.ClientConfig()
-		grpclog.Infof("installing in %s", config.Host)
-		err := c.Create(in.Release.Namespace, bytes.NewBufferString(local), 500, false)
+	for _, u := range installs {
+		if fedlocal.IsEmptyManifest(u.target) {
+			continue
+		}
+		err = c.track(u, "install", func() error {
+			return fedlocal.CreateWithCRDs(u.cluster.Client, in.Release.Namespace, u.target, defaultTimeout, false)
+		})
 		if err != nil {
-			grpclog.Infof("error when creating release: %v", err)
+			log.Errorf("Error creating local objects: %v", err)
 			return &rudderAPI.InstallReleaseResponse{}, err
 		}
 	}
 
+	if err := runner.Run(releaseHooks, hooks.PostInstall); err != nil {
+		log.Errorf("Error running hooks: %v", err)
+		return &rudderAPI.InstallReleaseResponse{}, err
+	}
+
+	err = recordPrimary(in.Release, locals)
+	if err != nil {
+		log.Errorf("Error recording primary cluster: %v", err)
+	}
 	return &rudderAPI.InstallReleaseResponse{}, err
 }
 
+// hooksOf returns hooks of release for rudder to run. Tiller runs hooks in its own cluster only, releases setting
+// run-hooks in values are installed, upgraded, rolled back and deleted with --no-hooks and rudder runs their hooks
+// in federation and member clusters. Hooks of other releases are left to tiller.
+func hooksOf(log *logrus.Entry, release *releaseAPI.Release) ([]*fedlocal.Hook, error) {
+	if !fedlocal.GetRunHooks(release.Config) {
+		if len(release.Hooks) > 0 {
+			log.Info("Leaving hooks of the release to tiller, set run-hooks in values and use --no-hooks to run them in member clusters")
+		}
+		return nil, nil
+	}
+	scope, err := fedlocal.GetHookScope(release.Config)
+	if err != nil {
+		return nil, err
+	}
+	return fedlocal.ReleaseHooks(release.Hooks, scope)
+}
+
+// observeSplit counts objects of a release going to federation and member clusters. Only installs and upgrades
+// count, other RPCs split the same manifests again.
+func observeSplit(log *logrus.Entry, federated, local string) {
+	for placement, manifest := range map[string]string{"federation": federated, "local": local} {
+		objects, err := fedlocal.ObjectsOf(manifest)
+		if err != nil {
+			log.Warningf("Cannot count %s objects: %v", placement, err)
+			continue
+		}
+		metrics.SplitObjects.WithLabelValues(placement).Add(float64(len(objects)))
+	}
+}
+
+// localRules reads placement and cluster overrides of release, with its primary cluster taken from release state
+func localRules(release *releaseAPI.Release, clients []*fedlocal.Cluster) (*fedlocal.LocalRules, error) {
+	locals, err := fedlocal.GetLocalRules(release.Config, clients)
+	if err != nil {
+		return nil, err
+	}
+	return locals, locals.UsePrimary(fedlocal.NewReleaseState(clientset, release.Name))
+}
+
+// checkPolicy checks federated objects and targets of updates in member clusters against the policy of rudder,
+// together with hooks of the release run on events
+func checkPolicy(federated string, members []clusterUpdate, runner *fedlocal.HookRunner, releaseHooks []*fedlocal.Hook, events ...string) error {
+	rules, err := policy.Load(clientset, fedlocal.RudderNamespace())
+	if err != nil || rules == nil {
+		return err
+	}
+	//Hooks are checked where they run, together with the objects of the release
+	federatedHooks, memberHooks, err := runner.Manifests(releaseHooks, events...)
+	if err != nil {
+		return err
+	}
+	manifests := make(map[string]string, len(members))
+	for _, u := range members {
+		manifests[u.cluster.Name] = u.target + "\n" + memberHooks[u.cluster.Name]
+	}
+	return rules.Check(federated+"\n"+federatedHooks, manifests)
+}
+
+// checkClusterScoped refuses targets of updates in member clusters with new cluster-scoped objects, together with
+// hooks of the release run on events there, unless release allows them. Such objects ignore the release namespace
+// and would be shared by everything in every member cluster.
+func checkClusterScoped(release *releaseAPI.Release, members []clusterUpdate, runner *fedlocal.HookRunner, releaseHooks []*fedlocal.Hook, events ...string) error {
+	if fedlocal.GetAllowClusterScoped(release.Config) {
+		return nil
+	}
+	_, memberHooks, err := runner.Manifests(releaseHooks, events...)
+	if err != nil {
+		return err
+	}
+	return inParallel(members, func(u clusterUpdate) error {
+		target := u.target + "\n" + memberHooks[u.cluster.Name]
+		if fedlocal.IsEmptyManifest(target) {
+			return nil
+		}
+		objects, err := fedlocal.ClusterScoped(u.cluster.Client, release.Namespace, u.current, target)
+		if err != nil || len(objects) == 0 {
+			return err
+		}
+		return fmt.Errorf("cluster-scoped %s require allow-cluster-scoped: true in values", strings.Join(objects, ", "))
+	}).orNil()
+}
+
+// recordPrimary stores primary cluster of release with placement, unless one is recorded already
+func recordPrimary(release *releaseAPI.Release, locals *fedlocal.LocalRules) error {
+	if len(locals.Placement.Policies) == 0 {
+		return nil
+	}
+
+	state := fedlocal.NewReleaseState(clientset, release.Name)
+	recorded, err := state.ActivePrimary()
+	if err != nil || recorded != "" {
+		return err
+	}
+	primary, err := locals.Primary()
+	if err != nil || primary == "" {
+		return err
+	}
+	return state.SetActivePrimary(primary)
+}
+
 // DeleteRelease deletes a release in federation and federated clusters
-func (r *ReleaseModuleServiceServer) DeleteRelease(ctx context.Context, in *rudderAPI.DeleteReleaseRequest) (*rudderAPI.DeleteReleaseResponse, error) {
-	grpclog.Info("delete")
+func (r *ReleaseModuleServiceServer) DeleteRelease(ctx context.Context, in *rudderAPI.DeleteReleaseRequest) (_ *rudderAPI.DeleteReleaseResponse, err error) {
+	c := newCall(ctx, "delete", in.Release)
+	log := c.log
+	log.Info("Deleting")
+	c.start(in.Release, nil)
+	defer func() {
+		c.finish(err)
+		//Release state goes after the outcome is recorded on its ConfigMap
+		if err == nil {
+			err = fedlocal.NewReleaseState(clientset, in.Release.Name).Delete()
+			if err != nil {
+				log.Errorf("Error deleting release state: %v", err)
+			}
+		}
+	}()
 	resp := &rudderAPI.DeleteReleaseResponse{
 		Release: &releaseAPI.Release{},
 	}
 
-	federated, local, err := fedlocal.SplitManifestForFed(in.Release.Manifest)
+	_, fedClient, clients, err := fedlocal.GetAllClients()
 
 	if err != nil {
-		grpclog.Infof("error splitting manifests to delete: %v", err)
+		log.Errorf("Error getting clients: %v", err)
 		return resp, err
 	}
 
-	_, fedClient, clients, err := fedlocal.GetAllClients()
-
+	span, _ := tracing.StartSpan(ctx, "rewrite")
+	manifest, err := fedlocal.NewRewriter(clients).Rewrite(in.Release)
+	tracing.Finish(span, err)
 	if err != nil {
-		grpclog.Infof("Error getting clients: %v", err)
+		log.Errorf("Error rewriting manifest: %v", err)
 		return resp, err
 	}
 
-	errChan := make(chan error)
-	doneChan := make(chan bool)
+	releaseHooks, err := hooksOf(log, in.Release)
+	if err != nil {
+		log.Errorf("Error reading hooks: %v", err)
+		return resp, err
+	}
 
-	deleter := func(client *kube.Client, manifest string) {
-		errs := make([]error, 0)
-		host := ""
+	span, _ = tracing.StartSpan(ctx, "split")
+	federated, local, err := fedlocal.SplitManifestForFed(manifest)
+	tracing.Finish(span, err)
 
-		defer func() {
-			grpclog.Infof("deletion in %v complete", host)
-			doneChan <- true
-			for _, err := range errs {
-				go func(e error) {
-					grpclog.Infof("error during deletion in %v: %s", host, err)
-					errChan <- e
-				}(err)
-			}
-		}()
+	if err != nil {
+		log.Errorf("Error splitting manifests to delete: %v", err)
+		return resp, err
+	}
 
-		config, err := client.ClientConfig()
+	locals, err := localRules(in.Release, clients)
+	if err != nil {
+		log.Errorf("Error reading placement and cluster overrides: %v", err)
+		return resp, err
+	}
+	clusterManifests := make([]string, len(clients))
+	for i, cluster := range clients {
+		clusterManifests[i], err = locals.ManifestForCluster(local, cluster)
 		if err != nil {
-			errs = append(errs, err)
-			return
+			logging.ForCluster(log, cluster.Name, cluster.Host).Errorf("Error placing local objects: %v", err)
+			return resp, err
 		}
-		host = config.Host
-		grpclog.Infof("Deleting in %v", host)
+	}
 
-		clientset, err := client.ClientSet()
-		if err != nil {
-			errs = append(errs, err)
-			return
-		}
+	//Deleting CRDs deletes all custom resources of their kinds, including ones which are not part of the release
+	deleteCRDs := fedlocal.GetDeleteCRDs(in.Release.Config)
+	deletionTimeout := boundedTimeout(ctx, fedlocal.GetDeleteTimeout(in.Release.Config))
 
-		versionset, err := tiller.GetVersionSet(clientset.Discovery())
-		if err != nil {
-			errs = append(errs, err)
-			return
+	runner := &fedlocal.HookRunner{Federation: fedClient, Locals: locals, Namespace: in.Release.Namespace, Timeout: defaultTimeout, Log: log}
+	if err := runner.Run(releaseHooks, hooks.PreDelete); err != nil {
+		log.Errorf("Error running hooks: %v", err)
+		return resp, err
+	}
+
+	//Federation deletes copies of federated objects in member clusters itself, if asked to cascade
+	fed := clusterUpdate{cluster: fedlocal.FederationCluster(fedClient), current: federated}
+	err = c.track(fed, "delete", func() error {
+		return fedlocal.DeleteFromFederation(fedClient, in.Release.Namespace, federated)
+	})
+	if err != nil {
+		log.Errorf("Error while deleting: %v", err)
+		return resp, err
+	}
+
+	deletions := make([]clusterUpdate, len(clients))
+	for i, cluster := range clients {
+		deletions[i] = clusterUpdate{cluster: cluster, current: clusterManifests[i]}
+	}
+
+	log.Infof("Waiting for deletions to finish")
+	errs := inParallel(deletions, c.tracked("delete", func(u clusterUpdate) error {
+		if err := deleteLocal(u.cluster.Client, in.Release, u.current, deleteCRDs); err != nil {
+			return err
 		}
+		return fedlocal.WaitForDeletion(u.cluster.Client, in.Release.Namespace, federated, deletionTimeout)
+	}))
+	if err := errs.orNil(); err != nil {
+		log.Errorf("Error while deleting: %v", err)
+		return resp, err
+	}
 
-		release := *in.Release
-		release.Manifest = manifest
-		_, errs = tiller.DeleteRelease(&release, versionset, client)
+	if err := runner.Run(releaseHooks, hooks.PostDelete); err != nil {
+		log.Errorf("Error running hooks: %v", err)
+		return resp, err
 	}
 
-	go deleter(fedClient, federated)
+	log.Info("Finished deletion")
+	return resp, nil
+}
 
-	for _, client := range clients {
-		go deleter(client, local)
+// deleteLocal deletes local objects of a release in a member cluster
+func deleteLocal(client *kube.Client, in *releaseAPI.Release, manifest string, deleteCRDs bool) error {
+	clientset, err := client.ClientSet()
+	if err != nil {
+		return err
+	}
+	versionset, err := tiller.GetVersionSet(clientset.Discovery())
+	if err != nil {
+		return err
 	}
 
-	//Waiting for all upgraders to finish (successful or not)
-	grpclog.Infof("Waiting for deletions to finish")
-	for i := 0; i < len(clients)+1; i++ {
-		<-doneChan
+	crds, rest, err := fedlocal.SplitCRDs(manifest)
+	if err != nil {
+		return err
 	}
 
-	select {
-	case err = <-errChan:
-		grpclog.Infof("Error while deleting: %v", err)
-		return resp, err
-	default:
+	release := *in
+	release.Manifest = rest
+	_, errs := tiller.DeleteRelease(&release, versionset, client)
+
+	//CRDs go last, when custom resources of their kinds are gone already
+	if deleteCRDs && len(errs) == 0 && !fedlocal.IsEmptyManifest(crds) {
+		release.Manifest = crds
+		_, errs = tiller.DeleteRelease(&release, versionset, client)
 	}
-	grpclog.Infof("Finished deletion")
-	return resp, err
+
+	if len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		return errors.New(strings.Join(messages, "; "))
+	}
+	return nil
 }
 
 // RollbackRelease rolls back the release
 func (r *ReleaseModuleServiceServer) RollbackRelease(ctx context.Context, in *rudderAPI.RollbackReleaseRequest) (*rudderAPI.RollbackReleaseResponse, error) {
-	grpclog.Info("rollback")
-
-	err := updateRelease(in.Current.Manifest, in.Target.Manifest, in.Target.Namespace, in.Force, in.Recreate, in.Wait, in.Timeout)
+	c := newCall(ctx, "rollback", in.Target)
+	c.log.Info("Rolling back")
+	c.start(in.Current, in.Target)
+
+	opts, err := newUpdateOptions(in.Target, in.Force, in.Recreate, in.Wait, in.Timeout)
+	if err == nil {
+		opts.preHook, opts.postHook = hooks.PreRollback, hooks.PostRollback
+		err = updateRelease(c, in.Current, in.Target, opts)
+	}
+	c.finish(err)
 	if err != nil {
-		grpclog.Warningf("Error rolling back release: %v", err)
+		c.log.Warningf("Error rolling back release: %v", err)
 	}
 	return &rudderAPI.RollbackReleaseResponse{}, err
 }
 
 // UpgradeRelease upgrades manifests using kubernetes client
 func (r *ReleaseModuleServiceServer) UpgradeRelease(ctx context.Context, in *rudderAPI.UpgradeReleaseRequest) (*rudderAPI.UpgradeReleaseResponse, error) {
-	grpclog.Info("upgrade")
-
-	err := updateRelease(in.Current.Manifest, in.Target.Manifest, in.Target.Namespace, in.Force, in.Recreate, in.Wait, in.Timeout)
+	c := newCall(ctx, "upgrade", in.Target)
+	c.log.Info("Upgrading")
+	c.start(in.Current, in.Target)
+
+	opts, err := newUpdateOptions(in.Target, in.Force, in.Recreate, in.Wait, in.Timeout)
+	if err == nil {
+		opts.preHook, opts.postHook = hooks.PreUpgrade, hooks.PostUpgrade
+		err = updateRelease(c, in.Current, in.Target, opts)
+	}
+	c.finish(err)
 	if err != nil {
-		grpclog.Warningf("Error updating release: %v", err)
+		c.log.Warningf("Error updating release: %v", err)
 	}
 	return &rudderAPI.UpgradeReleaseResponse{}, err
 }
 
-func updateRelease(current, target, namespace string, force, recreate, wait bool, timeout int64) error {
-	federatedCurrent, localCurrent, err := fedlocal.SplitManifestForFed(current)
+// updateOptions holds settings of a single upgrade or rollback
+type updateOptions struct {
+	namespace string
+	force     bool
+	recreate  bool
+	wait      bool
+	reconcile bool
+	timeout   int64
+	rollout   *fedlocal.Rollout
+	//Hook events run before and after the update
+	preHook  string
+	postHook string
+	//Revert clusters which were updated when update fails in any other cluster
+	rollbackOnFailure bool
+}
+
+func newUpdateOptions(target *releaseAPI.Release, force, recreate, wait bool, timeout int64) (updateOptions, error) {
+	rollout, err := fedlocal.GetRollout(target.Config)
+	return updateOptions{
+		namespace: target.Namespace,
+		force:     force,
+		recreate:  recreate,
+		wait:      wait,
+		reconcile: fedlocal.GetReconcile(target.Config),
+		timeout:   timeout,
+		rollout:   rollout,
+
+		rollbackOnFailure: fedlocal.GetRollbackOnFailure(target.Config),
+	}, err
+}
+
+// waitTimeout converts timeout of a request in seconds, falling back to the default delete timeout when the request
+// has none
+func waitTimeout(seconds int64) time.Duration {
+	if seconds <= 0 {
+		return fedlocal.DefaultDeleteTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// boundedTimeout shortens timeout to the deadline of ctx, so waits end before the caller gives up on the request
+func boundedTimeout(ctx context.Context, timeout time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := deadline.Sub(time.Now()); left < timeout {
+			return left
+		}
+	}
+	return timeout
+}
+
+func updateRelease(c *call, current, target *releaseAPI.Release, opts updateOptions) error {
+	ctx, log := c.ctx, c.log
+	// Current release was validated when it was installed, only the target may bring broken rules
+	if err := fedlocal.ValidateUpdate(current, target); err != nil {
+		log.Warningf("Error validating release: %v", err)
+		return err
+	}
+
+	_, fedClient, clients, err := fedlocal.GetAllClients()
 
 	if err != nil {
-		grpclog.Warningf("Error splitting manifest: %v", err)
+		log.Warningf("Error getting clients: %v", err)
 		return err
 	}
 
-	federatedTarget, localTarget, err := fedlocal.SplitManifestForFed(target)
+	span, _ := tracing.StartSpan(ctx, "rewrite")
+	currentManifest, targetManifest, err := fedlocal.NewRewriter(clients).RewriteUpdate(current, target)
+	tracing.Finish(span, err)
+	if err != nil {
+		log.Warningf("Error rewriting manifests: %v", err)
+		return err
+	}
 
+	//Only hooks of the target release run, hooks of the current one are already done
+	releaseHooks, err := hooksOf(log, target)
 	if err != nil {
-		grpclog.Warningf("Error splitting manifest: %v", err)
+		log.Warningf("Error reading hooks: %v", err)
 		return err
 	}
 
-	_, fedClient, clients, err := fedlocal.GetAllClients()
+	span, _ = tracing.StartSpan(ctx, "split")
+	federatedCurrent, localCurrent, err := fedlocal.SplitManifestForFed(currentManifest)
+
+	if err != nil {
+		tracing.Finish(span, err)
+		log.Warningf("Error splitting manifest: %v", err)
+		return err
+	}
+
+	federatedTarget, localTarget, err := fedlocal.SplitManifestForFed(targetManifest)
+	tracing.Finish(span, err)
+
+	if err != nil {
+		log.Warningf("Error splitting manifest: %v", err)
+		return err
+	}
+	if c.rpc == "upgrade" {
+		observeSplit(log, federatedTarget, localTarget)
+	}
 
+	currentLocals, err := localRules(current, clients)
+	if err != nil {
+		log.Warningf("Error reading placement and cluster overrides: %v", err)
+		return err
+	}
+	targetLocals, err := localRules(target, clients)
 	if err != nil {
-		grpclog.Warningf("Error getting clients: %v", err)
+		log.Warningf("Error reading placement and cluster overrides: %v", err)
 		return err
 	}
 
-	//We don't want errors to block goroutine
-	errChan := make(chan error, len(clients))
-	doneChan := make(chan bool)
+	federatedCurrent, err = currentLocals.AnnotatePrimary(federatedCurrent)
+	if err != nil {
+		return err
+	}
+	federatedTarget, err = targetLocals.AnnotatePrimary(federatedTarget)
+	if err != nil {
+		return err
+	}
 
-	upgrader := func(client *kube.Client, current, target string) {
-		config, _ := client.ClientConfig()
-		grpclog.Infof("Updating in %v", config.Host)
-		err := client.Update(namespace, bytes.NewBufferString(current), bytes.NewBufferString(target), force, recreate, timeout, wait)
-		doneChan <- true
+	moves, err := fedlocal.PlanMoves(federatedCurrent, localCurrent, federatedTarget, localTarget)
+	if err != nil {
+		log.Warningf("Error planning placement moves: %v", err)
+		return err
+	}
+	if !moves.Empty() {
+		log.Infof("Moving %v from federation to member clusters and %v to federation", moves.ToLocal, moves.ToFederation)
+	}
+	//Objects moving to member clusters are gone from federation by the time it is updated
+	fedCurrent, err := moves.FederationCurrent(federatedCurrent)
+	if err != nil {
+		return err
+	}
+	fed := clusterUpdate{
+		cluster: fedlocal.FederationCluster(fedClient),
+		current: fedCurrent,
+		target:  federatedTarget,
+	}
+	if opts.rollout != nil {
+		if err := checkRollout(fed); err != nil {
+			log.Warningf("Error checking rollout: %v", err)
+			return err
+		}
+	}
+
+	members := make([]clusterUpdate, 0, len(clients))
+	for _, cluster := range clients {
+		clusterCurrent, err := currentLocals.ManifestForCluster(localCurrent, cluster)
+		if err != nil {
+			return err
+		}
+		clusterCurrent, err = moves.MemberCurrent(clusterCurrent)
+		if err != nil {
+			return err
+		}
+		clusterTarget, err := targetLocals.ManifestForCluster(localTarget, cluster)
 		if err != nil {
-			grpclog.Warningf("Error updating in %s: %v", config.Host, err)
-			errChan <- err
+			return err
 		}
+		members = append(members, clusterUpdate{cluster: cluster, current: clusterCurrent, target: clusterTarget})
 	}
 
-	go upgrader(fedClient, federatedCurrent, federatedTarget)
+	runner := &fedlocal.HookRunner{Federation: fedClient, Locals: targetLocals, Namespace: opts.namespace, Timeout: opts.timeout, Log: log}
+	if err := checkPolicy(federatedTarget, members, runner, releaseHooks, opts.preHook, opts.postHook); err != nil {
+		log.Warningf("Error checking policy: %v", err)
+		return err
+	}
+	if err := checkClusterScoped(target, members, runner, releaseHooks, opts.preHook, opts.postHook); err != nil {
+		log.Warningf("Error checking cluster-scoped objects: %v", err)
+		return err
+	}
+
+	//Rollout waves have to be ready before next one starts
+	wait := opts.wait || opts.rollout != nil
+
+	apply := c.tracked("update", func(u clusterUpdate) error {
+		err := fedlocal.UpdateWithCRDs(u.cluster.Client, opts.namespace, u.current, u.target, opts.force, opts.recreate, opts.timeout, wait)
+		if err == nil && opts.reconcile {
+			u.logger(log).Info("Reconciling drifted objects")
+			err = fedlocal.Reconcile(u.cluster.Client, opts.namespace, u.target, opts.timeout)
+		}
+		return err
+	})
+
+	var reverted int32
+	revert := c.tracked("revert", func(u clusterUpdate) error {
+		atomic.AddInt32(&reverted, 1)
+		return fedlocal.UpdateWithCRDs(u.cluster.Client, opts.namespace, u.target, u.current, opts.force, opts.recreate, opts.timeout, false)
+	})
 
-	for _, client := range clients {
-		go upgrader(client, localCurrent, localTarget)
+	if err := runner.Run(releaseHooks, opts.preHook); err != nil {
+		return err
 	}
 
-	//Waiting for all upgraders to finish (successful or not)
-	for i := 0; i < len(clients)+1; i++ {
-		<-doneChan
+	//Federation has to let go of objects moving to member clusters before members take them over
+	if len(moves.ToLocal) > 0 {
+		err := c.track(clusterUpdate{cluster: fed.cluster}, "hand-over", func() error {
+			return moves.HandOver(fedClient, opts.namespace, boundedTimeout(ctx, waitTimeout(opts.timeout)))
+		})
+		if err != nil {
+			log.Warningf("Error handing objects over to member clusters: %v", err)
+			return err
+		}
 	}
 
-	select {
-	case err = <-errChan:
+	if opts.rollout != nil {
+		err = rollOut(ctx, log, opts.rollout, fed, members, apply, revert)
+	} else {
+		err = updateAtOnce(log, append(members, fed), apply, revert, opts.rollbackOnFailure)
+	}
+	//Reverted members run objects handed over to them in their previous version, federation owns them again
+	if err != nil && len(moves.ToLocal) > 0 && atomic.LoadInt32(&reverted) > 0 {
+		takeBack := c.track(clusterUpdate{cluster: fed.cluster}, "take-back", func() error {
+			return moves.TakeBack(fedClient, opts.namespace, opts.timeout)
+		})
+		if takeBack != nil {
+			return fmt.Errorf("%v; federation cannot take back %v: %v", err, moves.ToLocal, takeBack)
+		}
+	}
+	if err != nil {
 		return err
-	default:
 	}
 
-	return nil
+	if err := runner.Run(releaseHooks, opts.postHook); err != nil {
+		return err
+	}
+	return recordPrimary(target, targetLocals)
+}
+
+// updateAtOnce applies all updates in parallel, reverting all of them when any fails and rollbackOnFailure is set.
+// Clusters where the update failed are reverted too, as the update may have changed some of their objects.
+func updateAtOnce(log *logrus.Entry, updates []clusterUpdate, apply, revert func(clusterUpdate) error, rollbackOnFailure bool) error {
+	errs := inParallel(updates, apply)
+	if len(errs) == 0 || !rollbackOnFailure {
+		return errs.orNil()
+	}
+	return revertUpdated(log, errs, updates, revert)
 }
 
 func (r *ReleaseModuleServiceServer) ReleaseStatus(ctx context.Context, in *rudderAPI.ReleaseStatusRequest) (*rudderAPI.ReleaseStatusResponse, error) {
-	grpclog.Info("status")
+	c := newCall(ctx, "status", in.Release)
+	log := c.log
+	log.Info("Getting status")
 
-	federated, local, err := fedlocal.SplitManifestForFed(in.Release.Manifest)
+	_, fedClient, clients, err := fedlocal.GetAllClients()
+	if err != nil {
+		log.Errorf("Error getting clients: %v", err)
+		return &rudderAPI.ReleaseStatusResponse{}, err
+	}
 
+	//Status has to see the same objects install and upgrade created, otherwise rewritten fields show up as drift
+	span, _ := tracing.StartSpan(ctx, "rewrite")
+	manifest, err := fedlocal.NewRewriter(clients).Rewrite(in.Release)
+	tracing.Finish(span, err)
 	if err != nil {
-		grpclog.Infof("error splitting manifests: %v", err)
+		log.Errorf("Error rewriting manifest: %v", err)
 		return &rudderAPI.ReleaseStatusResponse{}, err
 	}
 
-	_, fedClient, clients, err := fedlocal.GetAllClients()
+	span, _ = tracing.StartSpan(ctx, "split")
+	federated, local, err := fedlocal.SplitManifestForFed(manifest)
+	tracing.Finish(span, err)
+
 	if err != nil {
-		grpclog.Infof("Error getting clients: %v", err)
+		log.Errorf("Error splitting manifests: %v", err)
 		return &rudderAPI.ReleaseStatusResponse{}, err
 	}
 
-	responses := make([]string, 0, len(clients)+1)
-	resps := make(chan string)
+	locals, err := localRules(in.Release, clients)
+	if err != nil {
+		log.Errorf("Error reading placement and cluster overrides: %v", err)
+		return &rudderAPI.ReleaseStatusResponse{}, err
+	}
+
+	federated, err = locals.AnnotatePrimary(federated)
+	if err != nil {
+		log.Errorf("Error annotating federated services: %v", err)
+		return &rudderAPI.ReleaseStatusResponse{}, err
+	}
 
-	fedResponse, err := fedClient.Get(in.Release.Namespace, bytes.NewBufferString(federated))
+	responses := make([]string, 0, len(clients)+2)
+	resps := make(chan string)
+	drifts := make(chan []fedlocal.Drift, len(clients))
+	//We don't want errors to block goroutines
+	errchan := make(chan error, len(clients)+1)
+
+	var fedResponse string
+	err = c.track(clusterUpdate{cluster: fedlocal.FederationCluster(fedClient)}, "get", func() error {
+		var err error
+		fedResponse, err = fedClient.Get(in.Release.Namespace, bytes.NewBufferString(federated))
+		return err
+	})
 	if err != nil {
-		grpclog.Infof("Error getting response from federation: %v", err)
+		log.Errorf("Error getting response from federation: %v", err)
 		return &rudderAPI.ReleaseStatusResponse{}, err
 	}
 	fedResponse = "Federation resources:\n" + fedResponse
 	go func() { resps <- fedResponse }()
 
-	errchan := make(chan error)
-	for _, client := range clients {
-		go func(client *kube.Client) {
+	//Federated objects are compared with their copies in member clusters, comparing them with federation itself
+	//would only repeat what federation was last told
+	for _, cluster := range clients {
+		go func(cluster *fedlocal.Cluster) {
+			errs := []error{}
+			clusterLocal, err := locals.ManifestForCluster(local, cluster)
+			errs = append(errs, err)
+
 			var resp string
-			resp, err := client.Get(in.Release.Namespace, bytes.NewBufferString(local))
-			config, _ := client.ClientConfig()
+			if !fedlocal.IsEmptyManifest(clusterLocal) {
+				err = c.track(clusterUpdate{cluster: cluster}, "get", func() error {
+					var err error
+					resp, err = cluster.Get(in.Release.Namespace, bytes.NewBufferString(clusterLocal))
+					return err
+				})
+				errs = append(errs, err)
+			}
+			config, _ := cluster.ClientConfig()
 			resp = config.Host + " resources:\n" + resp
 			resps <- resp
-			if err != nil {
-				errchan <- err
+
+			drift := []fedlocal.Drift{}
+			if errs[0] == nil {
+				d, err := fedlocal.DetectFederatedDrift(cluster, in.Release.Namespace, federated)
+				errs = append(errs, err)
+				drift = append(drift, d...)
+
+				d, err = fedlocal.DetectDrift(cluster.Client, cluster.Name, in.Release.Namespace, clusterLocal)
+				errs = append(errs, err)
+				drift = append(drift, d...)
+			}
+			for _, err := range errs {
+				if err != nil {
+					errchan <- err
+					break
+				}
 			}
-		}(client)
+			drifts <- drift
+		}(cluster)
 	}
 
 	for i := 0; i < len(clients)+1; i++ {
 		responses = append(responses, <-resps)
 	}
 
+	driftReport := []string{}
+	for i := 0; i < len(clients); i++ {
+		for _, d := range <-drifts {
+			driftReport = append(driftReport, d.String())
+		}
+	}
+	if len(driftReport) > 0 {
+		sort.Strings(driftReport)
+		responses = append(responses, "Drifted resources:\n"+strings.Join(driftReport, "\n")+"\n")
+	}
+
+	if len(locals.Placement.Policies) > 0 {
+		primary, err := locals.Primary()
+		if err != nil {
+			log.Errorf("Error getting primary cluster: %v", err)
+			return &rudderAPI.ReleaseStatusResponse{}, err
+		}
+		if locals.PrimaryLost() {
+			primary += " (not a member of the federation, run failover)"
+		}
+		responses = append(responses, "Primary cluster: "+primary+"\n")
+	}
+
 	select {
 	case err = <-errchan:
-		grpclog.Infof("Error getting response from federated cluster: %v", err)
+		log.Errorf("Error getting response from federated cluster: %v", err)
 		return &rudderAPI.ReleaseStatusResponse{}, err
 	default:
 	}
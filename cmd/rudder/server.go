@@ -0,0 +1,131 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"k8s.io/helm/pkg/rudder"
+)
+
+// Every server flag can be set with an environment variable too, flags take precedence
+var (
+	listenAddress = flag.String("listen-address", envOr("RUDDER_LISTEN_ADDRESS", "127.0.0.1"), "address the gRPC server listens on (RUDDER_LISTEN_ADDRESS)")
+	port          = flag.Int("port", envPort("RUDDER_PORT", rudder.GrpcPort), "port the gRPC server listens on (RUDDER_PORT)")
+	tlsCert       = flag.String("tls-cert", os.Getenv("RUDDER_TLS_CERT"), "PEM server certificate, enables TLS (RUDDER_TLS_CERT)")
+	tlsKey        = flag.String("tls-key", os.Getenv("RUDDER_TLS_KEY"), "PEM key of the server certificate (RUDDER_TLS_KEY)")
+	tlsClientCA   = flag.String("tls-client-ca", os.Getenv("RUDDER_TLS_CLIENT_CA"), "PEM CA bundle, clients have to present certificates signed by it (RUDDER_TLS_CLIENT_CA)")
+	logLevel      = flag.String("log-level", envOr("RUDDER_LOG_LEVEL", "info"), "log level: debug, info, warning or error (RUDDER_LOG_LEVEL)")
+	logFormat     = flag.String("log-format", envOr("RUDDER_LOG_FORMAT", "json"), "log format: json or logfmt (RUDDER_LOG_FORMAT)")
+	traceExporter = flag.String("trace-exporter", envOr("RUDDER_TRACE_EXPORTER", "none"), "where to send spans: none, file or otlp (RUDDER_TRACE_EXPORTER)")
+	traceTarget   = flag.String("trace-target", os.Getenv("RUDDER_TRACE_TARGET"), "file spans are written to (- for stdout), or OTLP/HTTP endpoint like http://otel-collector:4318 (RUDDER_TRACE_TARGET)")
+	auditSinkName = flag.String("audit-sink", envOr("RUDDER_AUDIT_SINK", "none"), "where to record audit of release changes: none or file (RUDDER_AUDIT_SINK)")
+	auditTarget   = flag.String("audit-target", os.Getenv("RUDDER_AUDIT_TARGET"), "file audit records are appended to, - for stdout (RUDDER_AUDIT_TARGET)")
+	gracePeriod   = flag.Duration("shutdown-grace-period", envDuration("RUDDER_SHUTDOWN_GRACE_PERIOD", 5*time.Minute), "time running operations are given to finish on SIGTERM (RUDDER_SHUTDOWN_GRACE_PERIOD)")
+)
+
+func envOr(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+func envPort(name string, def int) int {
+	port, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return port
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	duration, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return duration
+}
+
+// grpcAddr is the address the gRPC server listens on
+func grpcAddr() string {
+	return net.JoinHostPort(*listenAddress, strconv.Itoa(*port))
+}
+
+// serverCredentials returns TLS credentials of the gRPC server, or nil for plaintext when no certificate is set.
+// With clientCA set, clients are required to present certificates signed by it.
+func serverCredentials(cert, key, clientCA string) (credentials.TransportCredentials, error) {
+	if cert == "" && key == "" {
+		if clientCA != "" {
+			return nil, fmt.Errorf("client CA needs TLS, set server certificate and key too")
+		}
+		return nil, nil
+	}
+	if cert == "" || key == "" {
+		return nil, fmt.Errorf("both TLS certificate and key are required")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load TLS certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCA != "" {
+		pem, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", clientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return credentials.NewTLS(config), nil
+}
+
+// chainUnary runs interceptors in order, the first one outermost, as gRPC server takes a single one
+func chainUnary(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+		return next(ctx, req)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate and its key to dir, returning their paths
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rudder"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	cert, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeFile(t, cert, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeFile(t, keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	return cert, keyFile
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+}
+
+func TestServerCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rudder-tls")
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cert, key := writeCertificate(t, dir)
+	badCA := filepath.Join(dir, "bad-ca.crt")
+	writeFile(t, badCA, "not a certificate")
+	missing := filepath.Join(dir, "missing.crt")
+
+	tests := []struct {
+		name     string
+		cert     string
+		key      string
+		clientCA string
+		// part of the error, empty when credentials are expected
+		err string
+		tls bool
+	}{
+		{name: "plaintext"},
+		{name: "TLS", cert: cert, key: key, tls: true},
+		{name: "mutual TLS", cert: cert, key: key, clientCA: cert, tls: true},
+		{name: "certificate without key", cert: cert, err: "both TLS certificate and key are required"},
+		{name: "key without certificate", key: key, err: "both TLS certificate and key are required"},
+		{name: "missing certificate and key", cert: missing, key: missing, err: "cannot load TLS certificate"},
+		{name: "certificate and key mismatch", cert: key, key: cert, err: "cannot load TLS certificate"},
+		{name: "client CA without TLS", clientCA: cert, err: "client CA needs TLS"},
+		{name: "missing client CA", cert: cert, key: key, clientCA: missing, err: "cannot read client CA"},
+		{name: "bad client CA", cert: cert, key: key, clientCA: badCA, err: "no certificates found in client CA"},
+	}
+
+	for _, test := range tests {
+		creds, err := serverCredentials(test.cert, test.key, test.clientCA)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected no errors, got %v", test.name, err)
+			continue
+		}
+		if test.tls && (creds == nil || creds.Info().SecurityProtocol != "tls") {
+			t.Errorf("%s: expected TLS credentials, got %v", test.name, creds)
+		}
+		if !test.tls && creds != nil {
+			t.Errorf("%s: expected plaintext, got %v", test.name, creds.Info())
+		}
+	}
+}
+
+func TestServerFlagPrecedence(t *testing.T) {
+	defer os.Unsetenv("RUDDER_TEST_PORT")
+	defer os.Unsetenv("RUDDER_TEST_ADDRESS")
+	defer os.Unsetenv("RUDDER_TEST_GRACE_PERIOD")
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		address string
+		port    int
+		grace   time.Duration
+	}{
+		{name: "defaults", address: "127.0.0.1", port: 44134, grace: 5 * time.Minute},
+		{
+			name:    "environment",
+			env:     map[string]string{"RUDDER_TEST_ADDRESS": "0.0.0.0", "RUDDER_TEST_PORT": "44135", "RUDDER_TEST_GRACE_PERIOD": "30s"},
+			address: "0.0.0.0", port: 44135, grace: 30 * time.Second,
+		},
+		{
+			name:    "flags over environment",
+			env:     map[string]string{"RUDDER_TEST_ADDRESS": "0.0.0.0", "RUDDER_TEST_PORT": "44135", "RUDDER_TEST_GRACE_PERIOD": "30s"},
+			args:    []string{"--listen-address", "::1", "--port", "44136", "--shutdown-grace-period", "1m"},
+			address: "::1", port: 44136, grace: time.Minute,
+		},
+		{
+			name:    "invalid environment falls back to defaults",
+			env:     map[string]string{"RUDDER_TEST_PORT": "rudder", "RUDDER_TEST_GRACE_PERIOD": "soon"},
+			address: "127.0.0.1", port: 44134, grace: 5 * time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		for _, name := range []string{"RUDDER_TEST_ADDRESS", "RUDDER_TEST_PORT", "RUDDER_TEST_GRACE_PERIOD"} {
+			os.Setenv(name, test.env[name])
+		}
+		//Declared like server flags, with defaults from environment
+		flags := flag.NewFlagSet(test.name, flag.ContinueOnError)
+		address := flags.String("listen-address", envOr("RUDDER_TEST_ADDRESS", "127.0.0.1"), "")
+		port := flags.Int("port", envPort("RUDDER_TEST_PORT", 44134), "")
+		grace := flags.Duration("shutdown-grace-period", envDuration("RUDDER_TEST_GRACE_PERIOD", 5*time.Minute), "")
+		if err := flags.Parse(test.args); err != nil {
+			t.Fatalf("%s: expected no errors, got %v", test.name, err)
+		}
+
+		if *address != test.address || *port != test.port || *grace != test.grace {
+			t.Errorf("%s: expected %s, %d and %v, got %s, %d and %v", test.name, test.address, test.port, test.grace, *address, *port, *grace)
+		}
+	}
+}
+
+func TestGrpcAddr(t *testing.T) {
+	savedAddress, savedPort := *listenAddress, *port
+	defer func() { *listenAddress, *port = savedAddress, savedPort }()
+
+	tests := []struct {
+		address string
+		port    int
+		addr    string
+	}{
+		{"127.0.0.1", 44134, "127.0.0.1:44134"},
+		{"0.0.0.0", 44135, "0.0.0.0:44135"},
+		{"::1", 44134, "[::1]:44134"},
+		{"", 44134, ":44134"},
+	}
+	for _, test := range tests {
+		*listenAddress, *port = test.address, test.port
+		if addr := grpcAddr(); addr != test.addr {
+			t.Errorf("Expected %s, got %s", test.addr, addr)
+		}
+	}
+}
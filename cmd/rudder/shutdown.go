@@ -0,0 +1,117 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+)
+
+// shuttingDown is closed when rudder receives a signal to stop, so waits which would outlive
+// the grace period can be cut short
+var shuttingDown = make(chan struct{})
+
+// running holds cluster operations of all RPCs in progress
+var running = &inFlight{operations: map[string]int{}}
+
+// inFlight counts cluster operations which are running, by operation and cluster
+type inFlight struct {
+	mu         sync.Mutex
+	operations map[string]int
+}
+
+// start marks operation in cluster running until returned func is called
+func (f *inFlight) start(cluster, operation string) func() {
+	key := fmt.Sprintf("%s in %s", operation, cluster)
+	f.mu.Lock()
+	f.operations[key]++
+	f.mu.Unlock()
+
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.operations[key]--; f.operations[key] == 0 {
+			delete(f.operations, key)
+		}
+	}
+}
+
+// list returns operations which are running, sorted
+func (f *inFlight) list() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.operations))
+	for key := range f.operations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// stopper is a server stopOnSignal stops, like grpc.Server
+type stopper interface {
+	GracefulStop()
+	Stop()
+}
+
+// stopOnSignal stops server on SIGTERM or SIGINT. New RPCs are refused right away, running ones are given
+// gracePeriod to finish before the server is stopped, leaving operations still running in clusters incomplete.
+func stopOnSignal(server stopper, gracePeriod time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	stopOn(signals, server, gracePeriod)
+}
+
+func stopOn(signals <-chan os.Signal, server stopper, gracePeriod time.Duration) {
+	sig := <-signals
+	logging.Log.Infof("Received %v, waiting up to %v for running operations to finish", sig, gracePeriod)
+	close(shuttingDown)
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		logging.Log.Info("All operations finished")
+	case <-time.After(gracePeriod):
+		logging.Log.Warningf("Grace period is over, stopping with incomplete operations: %v", running.list())
+		server.Stop()
+	}
+}
+
+// serveUntilStopped runs serve until it returns. Stopping the server on a signal closes its listener, so serve
+// returns an error then too, which is expected: serveUntilStopped waits until stopping is done and returns nil.
+func serveUntilStopped(serve func() error, stopped <-chan struct{}) error {
+	err := serve()
+	select {
+	case <-shuttingDown:
+		<-stopped
+		return nil
+	default:
+		return err
+	}
+}
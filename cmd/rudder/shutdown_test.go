@@ -0,0 +1,175 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeServer finishes graceful stop once released, or when it is stopped
+type fakeServer struct {
+	released chan struct{}
+	stopped  chan struct{}
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{released: make(chan struct{}), stopped: make(chan struct{})}
+}
+
+func (s *fakeServer) GracefulStop() {
+	select {
+	case <-s.released:
+	case <-s.stopped:
+	}
+}
+
+func (s *fakeServer) Stop() {
+	close(s.stopped)
+}
+
+// withShutdown gives a test shutdown state of its own, shuttingDown can be closed only once
+func withShutdown() func() {
+	saved := shuttingDown
+	shuttingDown = make(chan struct{})
+	return func() { shuttingDown = saved }
+}
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	f := &inFlight{operations: map[string]int{}}
+	doneA := f.start("cluster-a", "update")
+	doneA2 := f.start("cluster-a", "update")
+	doneB := f.start("cluster-b", "revert")
+
+	if list := f.list(); !reflect.DeepEqual(list, []string{"revert in cluster-b", "update in cluster-a"}) {
+		t.Errorf("Expected both operations running, got %v", list)
+	}
+
+	doneA()
+	doneB()
+	if list := f.list(); !reflect.DeepEqual(list, []string{"update in cluster-a"}) {
+		t.Errorf("Expected update in cluster-a to run until both of its calls are done, got %v", list)
+	}
+	doneA2()
+	if list := f.list(); len(list) != 0 {
+		t.Errorf("Expected nothing running, got %v", list)
+	}
+}
+
+func TestStopOnSignalWaitsForOperations(t *testing.T) {
+	defer withShutdown()()
+	server := newFakeServer()
+	signals := make(chan os.Signal, 1)
+
+	returned := make(chan struct{})
+	go func() {
+		stopOn(signals, server, time.Minute)
+		close(returned)
+	}()
+	if isClosed(shuttingDown) {
+		t.Fatalf("Expected rudder not to be shutting down before a signal")
+	}
+
+	signals <- syscall.SIGTERM
+	select {
+	case <-shuttingDown:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected rudder to be shutting down after SIGTERM")
+	}
+	if isClosed(returned) {
+		t.Errorf("Expected stopping to wait for running operations")
+	}
+
+	close(server.released)
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected stopping to finish once operations are done")
+	}
+	if isClosed(server.stopped) {
+		t.Errorf("Expected server not to be stopped forcibly")
+	}
+}
+
+func TestStopOnSignalGracePeriodOver(t *testing.T) {
+	defer withShutdown()()
+	server := newFakeServer()
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGINT
+
+	done := running.start("cluster-a", "update")
+	defer done()
+
+	returned := make(chan struct{})
+	go func() {
+		stopOn(signals, server, 10*time.Millisecond)
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected stopping to end with the grace period")
+	}
+	if !isClosed(server.stopped) {
+		t.Errorf("Expected server to be stopped after the grace period")
+	}
+}
+
+func TestServeUntilStopped(t *testing.T) {
+	defer withShutdown()()
+	errClosed := errors.New("use of closed network connection")
+	serve := func() error { return errClosed }
+
+	//Serve failing on its own is an error
+	stopped := make(chan struct{})
+	if err := serveUntilStopped(serve, stopped); err != errClosed {
+		t.Errorf("Expected error of serve, got %v", err)
+	}
+
+	//Serve failing because the server is being stopped waits for stopping to finish
+	close(shuttingDown)
+	returned := make(chan error)
+	go func() { returned <- serveUntilStopped(serve, stopped) }()
+	select {
+	case err := <-returned:
+		t.Fatalf("Expected to wait until the server is stopped, returned %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(stopped)
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Errorf("Expected no errors once the server is stopped, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected to return once the server is stopped")
+	}
+}
@@ -67,7 +67,6 @@ var _ = Describe("Basic Suite", func() {
 		By("Rolling back release " + releaseName + "to a first revision")
 		Expect(helm.Rollback(releaseName, 1)).NotTo(HaveOccurred())
 		By("Deleting release " + releaseName)
-		//Timeout in delete in federation is a known issue. It can be flaky, so not checking it for now
-		helm.Delete(releaseName)
+		Expect(helm.Delete(releaseName)).NotTo(HaveOccurred())
 	})
 })
@@ -0,0 +1,190 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubernetes/pkg/kubectl/resource"
+
+	"k8s.io/helm/pkg/kube"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+const (
+	// DeleteFromUnderlyingClustersAnnotation makes federation delete copies of a federated object from member clusters
+	DeleteFromUnderlyingClustersAnnotation = "federation.kubernetes.io/delete-from-underlying-clusters"
+
+	resourcePolicyAnnotation = "helm.sh/resource-policy"
+	resourcePolicyKeep       = "keep"
+)
+
+// DefaultDeleteTimeout is how long member clusters are given to drop copies of deleted federated objects,
+// unless values set delete-timeout
+const DefaultDeleteTimeout = time.Minute
+
+type DeleteTimeoutExtractor struct {
+	DeleteTimeout int64 `json:"delete-timeout"`
+}
+
+// GetDeleteTimeout returns how long to wait for member clusters to drop copies of deleted federated objects,
+// delete-timeout in values is in seconds like --timeout of helm
+func GetDeleteTimeout(config *chart.Config) time.Duration {
+	extractor := DeleteTimeoutExtractor{}
+	err := yaml.Unmarshal([]byte(rawValues(config)), &extractor)
+	if err != nil {
+		logging.Log.Warningln("Error while unmarshalling raw config: ", err)
+	}
+
+	if extractor.DeleteTimeout <= 0 {
+		return DefaultDeleteTimeout
+	}
+	return time.Duration(extractor.DeleteTimeout) * time.Second
+}
+
+// deletionPollInterval is how often member clusters are checked while waiting for federation to delete objects
+var deletionPollInterval = 2 * time.Second
+
+// withoutKept drops objects annotated with helm.sh/resource-policy: keep, which helm never deletes
+func withoutKept(manifest string) (string, error) {
+	return filterObjects(manifest, func(o releaseutil.Manifest) (bool, error) {
+		return o.Metadata == nil || o.Metadata.Annotations[resourcePolicyAnnotation] != resourcePolicyKeep, nil
+	})
+}
+
+// DeleteFromFederation deletes federated objects of manifest together with their copies in member clusters.
+// Objects are annotated with delete-from-underlying-clusters first and then deleted without orphaning dependents,
+// which federation controllers take as a request to delete the copies too.
+func DeleteFromFederation(client *kube.Client, namespace, manifest string) error {
+	return deleteFromFederation(client, namespace, manifest, true)
+}
+
+// deletionRequest returns the patch annotating a federated object before its deletion and options of the deletion,
+// which either delete its copies in member clusters too or orphan them there
+func deletionRequest(cascade bool) (annotate []byte, options []byte, err error) {
+	annotate, err = json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{DeleteFromUnderlyingClustersAnnotation: strconv.FormatBool(cascade)},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	orphan := !cascade
+	options, err = json.Marshal(&v1.DeleteOptions{
+		TypeMeta:         v1.TypeMeta{Kind: "DeleteOptions", APIVersion: "v1"},
+		OrphanDependents: &orphan,
+	})
+	return annotate, options, err
+}
+
+func deleteFromFederation(client *kube.Client, namespace, manifest string, cascade bool) error {
+	manifest, err := withoutKept(manifest)
+	if err != nil || IsEmptyManifest(manifest) {
+		return err
+	}
+
+	infos, err := client.BuildUnstructured(namespace, bytes.NewBufferString(manifest))
+	if err != nil {
+		return err
+	}
+
+	annotate, options, err := deletionRequest(cascade)
+	if err != nil {
+		return err
+	}
+	from := "federation"
+	if cascade {
+		from += " and member clusters"
+	}
+
+	errs := []string{}
+	for _, info := range infos {
+		namespaced := info.Mapping.Scope.Name() == meta.RESTScopeNameNamespace
+		err := info.Client.Patch(types.MergePatchType).NamespaceIfScoped(info.Namespace, namespaced).
+			Resource(info.Mapping.Resource).Name(info.Name).Body(annotate).Do().Error()
+		if err == nil {
+			logging.Log.Infof("Deleting %s %s from %s", info.Mapping.GroupVersionKind.Kind, info.Name, from)
+			err = info.Client.Delete().NamespaceIfScoped(info.Namespace, namespaced).
+				Resource(info.Mapping.Resource).Name(info.Name).Body(options).Do().Error()
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Sprintf("%s %s: %v", info.Mapping.GroupVersionKind.Kind, info.Name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot delete from federation: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// WaitForDeletion waits until objects of manifest disappear from the cluster of client, like copies of federated
+// objects from a member cluster.
+// Objects still present after timeout are listed in the error.
+func WaitForDeletion(client *kube.Client, namespace, manifest string, timeout time.Duration) error {
+	manifest, err := withoutKept(manifest)
+	if err != nil || IsEmptyManifest(manifest) {
+		return err
+	}
+
+	infos, err := client.BuildUnstructured(namespace, bytes.NewBufferString(manifest))
+	if err != nil {
+		return err
+	}
+
+	remaining := infos
+	err = wait.PollImmediate(deletionPollInterval, timeout, func() (bool, error) {
+		present := []*resource.Info{}
+		for _, info := range remaining {
+			err := info.Get()
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			present = append(present, info)
+		}
+		remaining = present
+		return len(remaining) == 0, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		stragglers := []string{}
+		for _, info := range remaining {
+			stragglers = append(stragglers, info.Mapping.GroupVersionKind.Kind+"/"+info.Name)
+		}
+		return fmt.Errorf("%s still present after %v", strings.Join(stragglers, ", "), timeout)
+	}
+	return err
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"k8s.io/helm/pkg/kube"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// fakeAPIServer serves discovery of ConfigMaps and records requests to ConfigMaps in namespace default
+type fakeAPIServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+	bodies   map[string]string
+	// present is how many more GETs find a ConfigMap, -1 finds it forever
+	present map[string]int
+}
+
+func newFakeAPIServer(present map[string]int) *fakeAPIServer {
+	s := &fakeAPIServer{bodies: map[string]string{}, present: present}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *fakeAPIServer) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/api":
+		w.Write([]byte(`{"kind":"APIVersions","versions":["v1"]}`))
+		return
+	case "/apis":
+		w.Write([]byte(`{"kind":"APIGroupList","apiVersion":"v1","groups":[]}`))
+		return
+	case "/api/v1":
+		w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"v1","resources":[` +
+			`{"name":"configmaps","namespaced":true,"kind":"ConfigMap","verbs":["create","get","patch","delete"]}]}`))
+		return
+	}
+
+	name := path.Base(r.URL.Path)
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.Method == http.MethodPost && r.URL.Path == "/api/v1/namespaces/default/configmaps" {
+		created := struct {
+			Metadata v1.ObjectMeta `json:"metadata"`
+		}{}
+		json.Unmarshal(body, &created)
+		name = created.Metadata.Name
+	} else if !strings.HasPrefix(r.URL.Path, "/api/v1/namespaces/default/configmaps/") {
+		s.notFound(w, name)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	request := r.Method + " " + name
+	s.requests = append(s.requests, request)
+	s.bodies[request] = string(body)
+
+	remaining := s.present[name]
+	if r.Method == http.MethodGet && remaining > 0 {
+		s.present[name] = remaining - 1
+	}
+	if r.Method == http.MethodGet && remaining == 0 {
+		s.notFound(w, name)
+		return
+	}
+	w.Write([]byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"` + name + `","namespace":"default"}}`))
+}
+
+func (s *fakeAPIServer) notFound(w http.ResponseWriter, name string) {
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404,` +
+		`"message":"` + name + ` not found"}`))
+}
+
+func (s *fakeAPIServer) client() *kube.Client {
+	config := clientcmdapi.Config{
+		Clusters:       map[string]*clientcmdapi.Cluster{"fake": {Server: s.URL}},
+		Contexts:       map[string]*clientcmdapi.Context{"fake": {Cluster: "fake"}},
+		CurrentContext: "fake",
+	}
+	return kube.New(clientcmd.NewDefaultClientConfig(config, &clientcmd.ConfigOverrides{}))
+}
+
+var cascadeManifest = `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: wp4-config
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: wp4-kept
+  annotations:
+    helm.sh/resource-policy: keep
+---`
+
+func TestWithoutKept(t *testing.T) {
+	manifest := `---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: wp4-wordpress
+  annotations:
+    helm.sh/resource-policy: keep
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: wp4-wordpress
+---`
+	deleted, err := withoutKept(manifest)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if keys, _ := keysOf(deleted); !reflect.DeepEqual(keys, []string{"Deployment/wp4-wordpress"}) {
+		t.Errorf("Expected only Deployment to be deleted, got %v", keys)
+	}
+}
+
+func TestDeletionRequest(t *testing.T) {
+	tests := []struct {
+		cascade    bool
+		annotation string
+		orphan     bool
+	}{
+		{cascade: true, annotation: "true", orphan: false},
+		{cascade: false, annotation: "false", orphan: true},
+	}
+
+	for _, test := range tests {
+		annotate, options, err := deletionRequest(test.cascade)
+		if err != nil {
+			t.Fatalf("Expected no errors, got %v", err)
+		}
+
+		patch := struct {
+			Metadata struct {
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		}{}
+		if err := json.Unmarshal(annotate, &patch); err != nil {
+			t.Fatalf("Expected patch to be JSON, got %v", err)
+		}
+		if value := patch.Metadata.Annotations[DeleteFromUnderlyingClustersAnnotation]; value != test.annotation {
+			t.Errorf("Expected %s annotation %q with cascade %v, got %q", DeleteFromUnderlyingClustersAnnotation, test.annotation, test.cascade, value)
+		}
+
+		deleteOptions := v1.DeleteOptions{}
+		if err := json.Unmarshal(options, &deleteOptions); err != nil {
+			t.Fatalf("Expected options to be JSON, got %v", err)
+		}
+		if deleteOptions.OrphanDependents == nil || *deleteOptions.OrphanDependents != test.orphan {
+			t.Errorf("Expected OrphanDependents %v with cascade %v, got %v", test.orphan, test.cascade, deleteOptions.OrphanDependents)
+		}
+	}
+}
+
+func TestDeleteFromFederation(t *testing.T) {
+	server := newFakeAPIServer(map[string]int{})
+	defer server.Close()
+
+	if err := DeleteFromFederation(server.client(), "default", cascadeManifest); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	//Kept objects are left alone, the rest is annotated before it is deleted
+	expected := []string{"PATCH wp4-config", "DELETE wp4-config"}
+	if !reflect.DeepEqual(server.requests, expected) {
+		t.Fatalf("Expected requests %v, got %v", expected, server.requests)
+	}
+	if patch := server.bodies["PATCH wp4-config"]; !strings.Contains(patch, `"`+DeleteFromUnderlyingClustersAnnotation+`":"true"`) {
+		t.Errorf("Expected object to be annotated to delete its copies, got %s", patch)
+	}
+	if options := server.bodies["DELETE wp4-config"]; !strings.Contains(options, `"orphanDependents":false`) {
+		t.Errorf("Expected deletion without orphaning dependents, got %s", options)
+	}
+}
+
+func TestWaitForDeletion(t *testing.T) {
+	defer func(interval time.Duration) { deletionPollInterval = interval }(deletionPollInterval)
+	deletionPollInterval = 10 * time.Millisecond
+
+	server := newFakeAPIServer(map[string]int{"wp4-config": 2, "wp4-kept": -1})
+	defer server.Close()
+	if err := WaitForDeletion(server.client(), "default", cascadeManifest, time.Second); err != nil {
+		t.Errorf("Expected copies to be gone, got %v", err)
+	}
+
+	server = newFakeAPIServer(map[string]int{"wp4-config": -1})
+	defer server.Close()
+	err := WaitForDeletion(server.client(), "default", cascadeManifest, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "ConfigMap/wp4-config still present") {
+		t.Errorf("Expected ConfigMap to be reported as left behind, got %v", err)
+	}
+}
+
+func TestGetDeleteTimeout(t *testing.T) {
+	if timeout := GetDeleteTimeout(&chart.Config{Raw: "delete-timeout: 30"}); timeout != 30*time.Second {
+		t.Errorf("Expected 30s from values, got %v", timeout)
+	}
+	if timeout := GetDeleteTimeout(&chart.Config{Raw: ""}); timeout != DefaultDeleteTimeout {
+		t.Errorf("Expected default timeout, got %v", timeout)
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"k8s.io/helm/pkg/kube"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+const crdKind = "CustomResourceDefinition"
+
+// crdPollInterval is how often CRDs are checked while waiting for them to become established
+var crdPollInterval = time.Second
+
+type DeleteCRDsExtractor struct {
+	DeleteCRDs bool `json:"delete-crds"`
+}
+
+// GetDeleteCRDs tells if CRDs of the release should be deleted together with the release
+func GetDeleteCRDs(config *chart.Config) bool {
+	extractor := DeleteCRDsExtractor{}
+	err := yaml.Unmarshal([]byte(rawValues(config)), &extractor)
+	if err != nil {
+		logging.Log.Warningln("Error while unmarshalling raw config: ", err)
+	}
+
+	return extractor.DeleteCRDs
+}
+
+// SplitCRDs separates CustomResourceDefinitions from the rest of manifest
+func SplitCRDs(manifest string) (crds string, rest string, err error) {
+	isCRD := func(o releaseutil.Manifest) (bool, error) { return o.Kind == crdKind, nil }
+	crds, err = filterObjects(manifest, isCRD)
+	if err != nil {
+		return "", "", err
+	}
+	rest, err = filterObjects(manifest, func(o releaseutil.Manifest) (bool, error) {
+		keep, err := isCRD(o)
+		return !keep, err
+	})
+	return crds, rest, err
+}
+
+// crdSpec is the part of a CRD describing custom resources it defines
+type crdSpec struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Scope   string `json:"scope"`
+	Names   struct {
+		Kind string `json:"kind"`
+	} `json:"names"`
+}
+
+// GroupKind is group and kind of custom resources defined by the CRD
+func (s crdSpec) GroupKind() schema.GroupKind {
+	return schema.GroupKind{Group: s.Group, Kind: s.Names.Kind}
+}
+
+// crdSpecOf reads spec of CRD o
+func crdSpecOf(o releaseutil.Manifest) (crdSpec, error) {
+	crd := struct {
+		Spec crdSpec `json:"spec"`
+	}{}
+	err := yaml.Unmarshal([]byte(strings.Trim(o.Content, "- \t\n")), &crd)
+	return crd.Spec, err
+}
+
+// crdSpecs reads specs of all CRDs of manifest by their names
+func crdSpecs(manifest string) (map[string]crdSpec, error) {
+	objects, err := releaseutil.SplitManifestsWithHeads(manifest)
+	if err != nil {
+		return nil, err
+	}
+	specs := map[string]crdSpec{}
+	for _, o := range objects {
+		if o.Kind != crdKind || o.Metadata == nil {
+			continue
+		}
+		spec, err := crdSpecOf(o)
+		if err != nil {
+			return nil, err
+		}
+		specs[o.Metadata.Name] = spec
+	}
+	return specs, nil
+}
+
+// retainedCRDs returns CRDs of current manifest which are still in target one, defining the same group and kind.
+// CRDs dropped from a release are left in clusters, as deleting them would delete all custom resources of their kinds.
+// A CRD changing group or kind of its custom resources is refused for the same reason.
+func retainedCRDs(current, target string) (string, error) {
+	targetSpecs, err := crdSpecs(target)
+	if err != nil {
+		return current, err
+	}
+
+	return filterObjects(current, func(o releaseutil.Manifest) (bool, error) {
+		if o.Kind != crdKind || o.Metadata == nil {
+			return false, nil
+		}
+		targetSpec, ok := targetSpecs[o.Metadata.Name]
+		if !ok {
+			return false, nil
+		}
+		spec, err := crdSpecOf(o)
+		if err != nil {
+			return false, err
+		}
+		if spec.GroupKind() != targetSpec.GroupKind() {
+			return false, fmt.Errorf("CRD would change custom resources it defines from %v to %v, which deletes all of them", spec.GroupKind(), targetSpec.GroupKind())
+		}
+		return true, nil
+	})
+}
+
+// crdClient creates and updates objects, like kube.Client
+type crdClient interface {
+	Create(namespace string, reader io.Reader, timeout int64, shouldWait bool) error
+	Update(namespace string, originalReader, targetReader io.Reader, force bool, recreate bool, timeout int64, shouldWait bool) error
+}
+
+// CreateWithCRDs creates CRDs of manifest first and waits for them to become established,
+// so that custom resources of the manifest can be created right after
+func CreateWithCRDs(client *kube.Client, namespace, manifest string, timeout int64, shouldWait bool) error {
+	return createWithCRDs(client, waitForCRDsWith(client, namespace, timeout), namespace, manifest, timeout, shouldWait)
+}
+
+func createWithCRDs(client crdClient, waitForCRDs func(crds string) error, namespace, manifest string, timeout int64, shouldWait bool) error {
+	crds, rest, err := SplitCRDs(manifest)
+	if err != nil {
+		return err
+	}
+
+	if !IsEmptyManifest(crds) {
+		if err := client.Create(namespace, bytes.NewBufferString(crds), timeout, false); err != nil {
+			return err
+		}
+		if err := waitForCRDs(crds); err != nil {
+			return err
+		}
+	}
+
+	if IsEmptyManifest(rest) {
+		return nil
+	}
+	return client.Create(namespace, bytes.NewBufferString(rest), timeout, shouldWait)
+}
+
+// UpdateWithCRDs updates CRDs before the rest of the release like CreateWithCRDs creates them.
+// CRDs are never deleted by update.
+func UpdateWithCRDs(client *kube.Client, namespace, current, target string, force, recreate bool, timeout int64, shouldWait bool) error {
+	return updateWithCRDs(client, waitForCRDsWith(client, namespace, timeout), namespace, current, target, force, recreate, timeout, shouldWait)
+}
+
+func updateWithCRDs(client crdClient, waitForCRDs func(crds string) error, namespace, current, target string, force, recreate bool, timeout int64, shouldWait bool) error {
+	currentCRDs, currentRest, err := SplitCRDs(current)
+	if err != nil {
+		return err
+	}
+	targetCRDs, targetRest, err := SplitCRDs(target)
+	if err != nil {
+		return err
+	}
+
+	if !IsEmptyManifest(targetCRDs) {
+		currentCRDs, err = retainedCRDs(currentCRDs, targetCRDs)
+		if err != nil {
+			return err
+		}
+		err = client.Update(namespace, bytes.NewBufferString(currentCRDs), bytes.NewBufferString(targetCRDs), force, recreate, timeout, false)
+		if err != nil {
+			return err
+		}
+		if err := waitForCRDs(targetCRDs); err != nil {
+			return err
+		}
+	}
+
+	return client.Update(namespace, bytes.NewBufferString(currentRest), bytes.NewBufferString(targetRest), force, recreate, timeout, shouldWait)
+}
+
+func waitForCRDsWith(client *kube.Client, namespace string, timeout int64) func(crds string) error {
+	return func(crds string) error {
+		return WaitForCRDs(client, namespace, crds, time.Duration(timeout)*time.Second)
+	}
+}
+
+// WaitForCRDs waits until all CRDs of manifest are established and client can map their custom resources
+func WaitForCRDs(client *kube.Client, namespace, crds string, timeout time.Duration) error {
+	infos, err := client.BuildUnstructured(namespace, bytes.NewBufferString(crds))
+	if err != nil {
+		return err
+	}
+
+	pending := []string{}
+	err = wait.PollImmediate(crdPollInterval, timeout, func() (bool, error) {
+		pending = []string{}
+		for _, info := range infos {
+			if err := info.Get(); err != nil {
+				return false, err
+			}
+			object, err := objectMap(info.Object)
+			if err != nil {
+				return false, err
+			}
+			if !crdEstablished(object) {
+				pending = append(pending, info.Name)
+			}
+		}
+		return len(pending) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("CRDs %s did not become established in %v", strings.Join(pending, ", "), timeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	return waitForMapping(client, crds, timeout)
+}
+
+// waitForMapping refreshes discovery until the REST mapper of client maps custom resources of all CRDs of manifest.
+// Create and Update build their mapper from the discovery cache every discovery client of client shares,
+// so the cache has to be read again, forgetting it in one discovery client is not enough.
+func waitForMapping(client *kube.Client, crds string, timeout time.Duration) error {
+	specs, err := crdSpecs(crds)
+	if err != nil {
+		return err
+	}
+
+	unmapped := []string{}
+	err = wait.PollImmediate(crdPollInterval, timeout, func() (bool, error) {
+		discovery, err := client.DiscoveryClient()
+		if err != nil {
+			return false, err
+		}
+		discovery.Invalidate()
+		//Reading all resources again writes them to the cache, groups of the CRDs may not be served yet
+		if _, err := discovery.ServerResources(); err != nil {
+			logging.Log.Debugf("Error refreshing discovery: %v", err)
+		}
+
+		mapper, _, err := client.UnstructuredObject()
+		if err != nil {
+			return false, err
+		}
+		unmapped = []string{}
+		for _, name := range sortedSpecNames(specs) {
+			spec := specs[name]
+			if _, err := mapper.RESTMapping(spec.GroupKind(), spec.Version); err != nil {
+				unmapped = append(unmapped, spec.GroupKind().String())
+			}
+		}
+		return len(unmapped) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("custom resources %s are not served in %v", strings.Join(unmapped, ", "), timeout)
+	}
+	return err
+}
+
+func sortedSpecNames(specs map[string]crdSpec) []string {
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func crdEstablished(crd map[string]interface{}) bool {
+	status, _ := crd["status"].(map[string]interface{})
+	conditions, _ := status["conditions"].([]interface{})
+	for _, c := range conditions {
+		condition, _ := c.(map[string]interface{})
+		if condition["type"] == "Established" && condition["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
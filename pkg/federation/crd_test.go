@@ -0,0 +1,208 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+var crdManifest = `---
+apiVersion: example.com/v1
+kind: Backup
+metadata:
+  name: wp4-nightly
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: backups.example.com
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: wp4-config
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: restores.example.com
+---`
+
+func TestSplitCRDs(t *testing.T) {
+	crds, rest, err := SplitCRDs(crdManifest)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	if names := placedNames(t, crds); !reflect.DeepEqual(names, []string{"backups.example.com", "restores.example.com"}) {
+		t.Errorf("Expected CRDs in their order, got %v", names)
+	}
+	if names := placedNames(t, rest); !reflect.DeepEqual(names, []string{"wp4-nightly", "wp4-config"}) {
+		t.Errorf("Expected the rest of objects in their order, got %v", names)
+	}
+}
+
+func TestRetainedCRDs(t *testing.T) {
+	crds, _, err := SplitCRDs(crdManifest)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	target := `---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: restores.example.com
+---`
+
+	retained, err := retainedCRDs(crds, target)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if names := placedNames(t, retained); !reflect.DeepEqual(names, []string{"restores.example.com"}) {
+		t.Errorf("Expected only CRDs present in target to be updated, got %v", names)
+	}
+}
+
+func TestRetainedCRDsChangingKind(t *testing.T) {
+	current := `---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: backups.example.com
+spec:
+  group: example.com
+  names:
+    kind: Backup
+---`
+	target := strings.Replace(current, "kind: Backup", "kind: Snapshot", 1)
+
+	if _, err := retainedCRDs(current, target); err == nil || !strings.Contains(err.Error(), "Snapshot.example.com") {
+		t.Errorf("Expected error about CRD changing its kind, got %v", err)
+	}
+	if retained, err := retainedCRDs(current, current); err != nil || !reflect.DeepEqual(placedNames(t, retained), []string{"backups.example.com"}) {
+		t.Errorf("Expected CRD defining the same kind to be retained, got %v, %v", retained, err)
+	}
+}
+
+// fakeCRDClient records creates, updates and waits for CRDs in order, as operation and names of objects
+type fakeCRDClient struct {
+	t     *testing.T
+	calls []string
+}
+
+func (f *fakeCRDClient) record(operation string, reader io.Reader) {
+	manifest, err := ioutil.ReadAll(reader)
+	if err != nil {
+		f.t.Fatalf("Expected no errors, got %v", err)
+	}
+	f.calls = append(f.calls, operation+" "+strings.Join(placedNames(f.t, string(manifest)), ","))
+}
+
+func (f *fakeCRDClient) Create(namespace string, reader io.Reader, timeout int64, shouldWait bool) error {
+	f.record("create", reader)
+	return nil
+}
+
+func (f *fakeCRDClient) Update(namespace string, originalReader, targetReader io.Reader, force bool, recreate bool, timeout int64, shouldWait bool) error {
+	f.record("update", targetReader)
+	return nil
+}
+
+func (f *fakeCRDClient) wait(crds string) error {
+	f.record("wait", strings.NewReader(crds))
+	return nil
+}
+
+func TestCreateWithCRDsOrder(t *testing.T) {
+	client := &fakeCRDClient{t: t}
+	if err := createWithCRDs(client, client.wait, "default", crdManifest, 300, false); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	expected := []string{
+		"create backups.example.com,restores.example.com",
+		"wait backups.example.com,restores.example.com",
+		"create wp4-nightly,wp4-config",
+	}
+	if !reflect.DeepEqual(client.calls, expected) {
+		t.Errorf("Expected CRDs created and waited for before the rest, got %v", client.calls)
+	}
+}
+
+func TestUpdateWithCRDsOrder(t *testing.T) {
+	client := &fakeCRDClient{t: t}
+	if err := updateWithCRDs(client, client.wait, "default", crdManifest, crdManifest, false, false, 300, false); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	expected := []string{
+		"update backups.example.com,restores.example.com",
+		"wait backups.example.com,restores.example.com",
+		"update wp4-nightly,wp4-config",
+	}
+	if !reflect.DeepEqual(client.calls, expected) {
+		t.Errorf("Expected CRDs updated and waited for before the rest, got %v", client.calls)
+	}
+
+	//Without CRDs in target, nothing is waited for
+	client = &fakeCRDClient{t: t}
+	if err := updateWithCRDs(client, client.wait, "default", crdManifest, "", false, false, 300, false); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if !reflect.DeepEqual(client.calls, []string{"update "}) {
+		t.Errorf("Expected only the rest of the release to be updated, got %v", client.calls)
+	}
+}
+
+func TestCRDEstablished(t *testing.T) {
+	established := toMap(t, `
+status:
+  conditions:
+  - type: NamesAccepted
+    status: "True"
+  - type: Established
+    status: "True"
+`)
+	if !crdEstablished(established) {
+		t.Errorf("Expected CRD to be established")
+	}
+
+	pending := toMap(t, `
+status:
+  conditions:
+  - type: Established
+    status: "False"
+`)
+	if crdEstablished(pending) || crdEstablished(map[string]interface{}{}) {
+		t.Errorf("Expected CRD not to be established")
+	}
+}
+
+func TestGetDeleteCRDs(t *testing.T) {
+	if GetDeleteCRDs(nil) {
+		t.Errorf("Expected CRDs to be kept by default")
+	}
+	if !GetDeleteCRDs(&chart.Config{Raw: "delete-crds: true"}) {
+		t.Errorf("Expected CRDs to be deleted when asked")
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+type FederatedDNSExtractor struct {
+	FederatedDNS bool `json:"federated-dns"`
+}
+
+// GetFederatedDNS tells if references to Services of the release should be rewritten to federation DNS names
+func GetFederatedDNS(config *chart.Config) bool {
+	extractor := FederatedDNSExtractor{}
+	err := yaml.Unmarshal([]byte(rawValues(config)), &extractor)
+	if err != nil {
+		logging.Log.Warningln("Error while unmarshalling raw config: ", err)
+	}
+
+	return extractor.FederatedDNS
+}
+
+// hostToken matches words which may be host names, with an optional port. Underscores are included so that parts of
+// identifiers like MY_SERVICE are not mistaken for host names.
+var hostToken = regexp.MustCompile(`[A-Za-z0-9_][A-Za-z0-9_.-]*(:[0-9]+)?`)
+
+// RewriteServiceReferences finds Services of the release and rewrites references to them in env variables,
+// commands and args of containers and in ConfigMap data to federation DNS form <svc>.<ns>.<federation>.svc.<zone>.
+// Services may be referenced as <svc>.<ns>, <svc>.<ns>.svc or <svc>.<ns>.svc.cluster.local, and as <svc> only
+// with a port, like <svc>:<port>. A bare <svc> is left alone, as it is as likely to be a word like api or redis
+// in a setting which has nothing to do with host names.
+func RewriteServiceReferences(manifest, namespace, federation, zone string) (string, error) {
+	if federation == "" || zone == "" {
+		return manifest, fmt.Errorf("federation name and DNS zone are needed to rewrite service references, got %q and %q", federation, zone)
+	}
+
+	objects, err := releaseutil.SplitManifestsWithHeads(manifest)
+	if err != nil {
+		return manifest, err
+	}
+
+	//Qualified host names are rewritten anywhere, bare Service names only when followed by a port
+	hosts := map[string]string{}
+	names := map[string]string{}
+	for _, o := range objects {
+		if o.Kind != "Service" || o.Metadata == nil {
+			continue
+		}
+		name := o.Metadata.Name
+		federated := fmt.Sprintf("%s.%s.%s.svc.%s", name, namespace, federation, strings.TrimSuffix(zone, "."))
+		names[name] = federated
+		for _, host := range []string{name + "." + namespace, name + "." + namespace + ".svc", name + "." + namespace + ".svc.cluster.local"} {
+			hosts[host] = federated
+		}
+	}
+	if len(names) == 0 {
+		return manifest, nil
+	}
+
+	rewrite := func(value string) string {
+		return hostToken.ReplaceAllStringFunc(value, func(token string) string {
+			host, port := token, ""
+			if i := strings.Index(token, ":"); i >= 0 {
+				host, port = token[:i], token[i:]
+			}
+			if federated, ok := hosts[host]; ok {
+				return federated + port
+			}
+			if federated, ok := names[host]; ok && port != "" {
+				return federated + port
+			}
+			return token
+		})
+	}
+
+	return transformObjects(manifest, func(object map[string]interface{}) (map[string]interface{}, error) {
+		changed := false
+		if object["kind"] == "ConfigMap" {
+			data, _ := object["data"].(map[string]interface{})
+			changed = rewriteStrings(data, rewrite)
+		} else {
+			changed = rewriteContainers(object, rewrite)
+		}
+
+		if changed {
+			return object, nil
+		}
+		return nil, nil
+	})
+}
+
+// rewriteContainers walks value looking for containers of pod specs and rewrites their env values, commands and args
+func rewriteContainers(value interface{}, rewrite func(string) string) bool {
+	changed := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if key != "containers" && key != "initContainers" {
+				changed = rewriteContainers(child, rewrite) || changed
+				continue
+			}
+
+			containers, _ := child.([]interface{})
+			for _, c := range containers {
+				container, ok := c.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				for _, field := range []string{"command", "args"} {
+					list, _ := container[field].([]interface{})
+					changed = rewriteStrings(list, rewrite) || changed
+				}
+				env, _ := container["env"].([]interface{})
+				for _, e := range env {
+					variable, _ := e.(map[string]interface{})
+					changed = rewriteStrings(variable, rewrite, "value") || changed
+				}
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			changed = rewriteContainers(child, rewrite) || changed
+		}
+	}
+	return changed
+}
+
+// rewriteStrings rewrites string values of a list or a map in place. For maps only given keys are rewritten, all when none are given.
+func rewriteStrings(values interface{}, rewrite func(string) string, keys ...string) bool {
+	changed := false
+	switch v := values.(type) {
+	case []interface{}:
+		for i, value := range v {
+			if s, ok := value.(string); ok {
+				if rewritten := rewrite(s); rewritten != s {
+					v[i] = rewritten
+					changed = true
+				}
+			}
+		}
+	case map[string]interface{}:
+		if len(keys) == 0 {
+			for key := range v {
+				keys = append(keys, key)
+			}
+		}
+		for _, key := range keys {
+			if s, ok := v[key].(string); ok {
+				if rewritten := rewrite(s); rewritten != s {
+					v[key] = rewritten
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+var dnsManifest = `---
+apiVersion: v1
+kind: Service
+metadata:
+  name: wp4-mariadb
+spec:
+  ports:
+  - port: 3306
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: wp4-config
+data:
+  wp-config.php: "define('DB_HOST', 'wp4-mariadb.default.svc.cluster.local:3306');"
+  database: wp4-mariadb_db
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: wp4-wordpress
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: wait
+        image: busybox
+        command: ["sh", "-c", "until nslookup wp4-mariadb.default; do sleep 1; done"]
+      containers:
+      - name: wordpress
+        image: bitnami/wordpress:4.7.3-r0
+        args: ["--db=tcp://wp4-mariadb:3306", "--name=wp4-mariadb-backup"]
+        env:
+        - name: MARIADB_HOST
+          value: wp4-mariadb:3306
+        - name: WP4_MARIADB_SERVICE
+          value: wp4-mariadb.default.svc
+---`
+
+func TestGetFederatedDNS(t *testing.T) {
+	if GetFederatedDNS(&chart.Config{Raw: "federated-dns: true"}) != true {
+		t.Errorf("Expected federated DNS to be enabled")
+	}
+	if GetFederatedDNS(nil) != false {
+		t.Errorf("Expected federated DNS to be disabled by default")
+	}
+}
+
+func TestRewriteServiceReferences(t *testing.T) {
+	rewritten, err := RewriteServiceReferences(dnsManifest, "default", "federation", "example.com.")
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	federated := "wp4-mariadb.default.federation.svc.example.com"
+	for _, expected := range []string{
+		federated + ":3306'",
+		"nslookup " + federated + ";",
+		"--db=tcp://" + federated + ":3306",
+	} {
+		if !strings.Contains(rewritten, expected) {
+			t.Errorf("Expected %q in rewritten manifest, got:\n%s", expected, rewritten)
+		}
+	}
+
+	for _, value := range []string{"value: " + federated + ":3306\n", "value: " + federated + "\n"} {
+		if !strings.Contains(rewritten, value) {
+			t.Errorf("Expected %q in rewritten env values, got:\n%s", value, rewritten)
+		}
+	}
+
+	for _, unchanged := range []string{
+		"name: wp4-mariadb\n",
+		"--name=wp4-mariadb-backup",
+		"database: wp4-mariadb_db",
+		"name: MARIADB_HOST",
+		"name: WP4_MARIADB_SERVICE",
+	} {
+		if !strings.Contains(rewritten, unchanged) {
+			t.Errorf("Expected %q to be left untouched, got:\n%s", unchanged, rewritten)
+		}
+	}
+}
+
+func TestRewriteServiceReferencesNeedsZone(t *testing.T) {
+	if _, err := RewriteServiceReferences(dnsManifest, "default", "federation", ""); err == nil {
+		t.Errorf("Expected error when DNS zone is unknown")
+	}
+}
+
+func TestRewriteWithFederatedDNS(t *testing.T) {
+	values := `federated-dns: true
+patches:
+  - match:
+      kind: Deployment
+    set:
+      spec.template.spec.containers[0].env[1].value: custom
+`
+	rewritten, err := testRewriter().Rewrite(testRelease(1, dnsManifest, values))
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	if !strings.Contains(rewritten, "value: wp4-mariadb.default.federation.svc.example.com") {
+		t.Errorf("Expected MARIADB_HOST to be rewritten to federated name, got:\n%s", rewritten)
+	}
+	if !strings.Contains(rewritten, "value: custom") {
+		t.Errorf("Expected patches to be applied after DNS rewriting, got:\n%s", rewritten)
+	}
+}
+
+func TestRewriteServiceReferencesLeavesBareNames(t *testing.T) {
+	manifest := `---
+apiVersion: v1
+kind: Service
+metadata:
+  name: api
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: api
+  greeting: call the api team
+  url: http://api:8080/v1
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  template:
+    spec:
+      containers:
+      - name: worker
+        image: worker
+        args: ["--backend", "api", "--log=api.log"]
+        env:
+        - name: MODE
+          value: api
+---`
+	rewritten, err := RewriteServiceReferences(manifest, "default", "federation", "example.com")
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	if !strings.Contains(rewritten, "url: http://api.default.federation.svc.example.com:8080/v1") {
+		t.Errorf("Expected Service name with port to be rewritten, got:\n%s", rewritten)
+	}
+	for _, unchanged := range []string{
+		"mode: api\n",
+		"greeting: call the api team",
+		`"--backend", "api"`,
+		"--log=api.log",
+		"value: api\n",
+	} {
+		if !strings.Contains(rewritten, unchanged) {
+			t.Errorf("Expected %q to be left untouched, got:\n%s", unchanged, rewritten)
+		}
+	}
+}
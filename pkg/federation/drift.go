@@ -0,0 +1,377 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+
+	"k8s.io/helm/pkg/kube"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+// ClusterSelectorAnnotation limits member clusters federation creates copies of a federated object in
+const ClusterSelectorAnnotation = "federation.alpha.kubernetes.io/cluster-selector"
+
+// Drift lists fields of a single object which differ between release manifest and the object found in a cluster
+type Drift struct {
+	Cluster string
+	Kind    string
+	Name    string
+	Fields  []string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s %s/%s: %s", d.Cluster, d.Kind, d.Name, strings.Join(d.Fields, ", "))
+}
+
+// missingField is reported instead of field paths when object does not exist in the cluster at all
+const missingField = "<missing>"
+
+// driftIgnored holds top level fields which are managed by the API server or are write-only
+var driftIgnored = map[string]bool{
+	"status":     true,
+	"stringData": true,
+}
+
+// federationScheduled holds fields of federated objects by kind, which federation splits between member clusters,
+// so copies in member clusters never have the declared value
+var federationScheduled = map[string][]string{
+	"Deployment": {"spec.replicas"},
+	"ReplicaSet": {"spec.replicas"},
+}
+
+type ReconcileExtractor struct {
+	Reconcile bool `json:"reconcile"`
+}
+
+// GetReconcile tells if upgrade should force declared state back onto objects which drifted from it
+func GetReconcile(config *chart.Config) bool {
+	extractor := ReconcileExtractor{}
+	err := yaml.Unmarshal([]byte(rawValues(config)), &extractor)
+	if err != nil {
+		logging.Log.Warningln("Error while unmarshalling raw config: ", err)
+	}
+
+	return extractor.Reconcile
+}
+
+func rawValues(config *chart.Config) string {
+	if config == nil {
+		return ""
+	}
+	return config.Raw
+}
+
+// IsEmptyManifest tells if manifest holds no objects, which kube client refuses to create or get
+func IsEmptyManifest(manifest string) bool {
+	return strings.Trim(manifest, "- \t\n") == ""
+}
+
+func objectMap(obj runtime.Object) (map[string]interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// DetectDrift compares every object from manifest with its live copy in the cluster client points at
+func DetectDrift(client *kube.Client, cluster, namespace, manifest string) ([]Drift, error) {
+	return detectDrift(client, cluster, namespace, manifest, nil)
+}
+
+// DetectFederatedDrift compares federated objects of manifest which federation places in cluster with their copies there.
+// Fields federation schedules across clusters, like replicas of Deployments, are not compared.
+func DetectFederatedDrift(cluster *Cluster, namespace, manifest string) ([]Drift, error) {
+	placed, err := PlacedByFederation(manifest, cluster)
+	if err != nil {
+		return nil, err
+	}
+	return detectDrift(cluster.Client, cluster.Name, namespace, placed, federationScheduled)
+}
+
+// PlacedByFederation returns federated objects of manifest which federation creates in cluster,
+// which are all of them except objects whose cluster selector doesn't match labels of the cluster
+func PlacedByFederation(manifest string, cluster *Cluster) (string, error) {
+	return filterObjects(manifest, func(o releaseutil.Manifest) (bool, error) {
+		if o.Metadata == nil || o.Metadata.Annotations[ClusterSelectorAnnotation] == "" {
+			return true, nil
+		}
+		selector, err := clusterSelector(o.Metadata.Annotations[ClusterSelectorAnnotation])
+		if err != nil {
+			return false, err
+		}
+		return selector.Matches(labels.Set(cluster.Labels)), nil
+	})
+}
+
+// clusterSelector parses cluster selector annotation, a JSON list of requirements like
+// [{"key": "pci", "operator": "In", "values": ["true"]}]
+func clusterSelector(annotation string) (labels.Selector, error) {
+	requirements := []struct {
+		Key      string   `json:"key"`
+		Operator string   `json:"operator"`
+		Values   []string `json:"values"`
+	}{}
+	if err := json.Unmarshal([]byte(annotation), &requirements); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", ClusterSelectorAnnotation, err)
+	}
+
+	selector := labels.NewSelector()
+	for _, r := range requirements {
+		operator := selection.Operator(strings.ToLower(r.Operator))
+		if operator == "doesnotexist" {
+			operator = selection.DoesNotExist
+		}
+		requirement, err := labels.NewRequirement(r.Key, operator, r.Values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", ClusterSelectorAnnotation, err)
+		}
+		selector = selector.Add(*requirement)
+	}
+	return selector, nil
+}
+
+// detectDrift compares objects of manifest with their live copies, skipping ignored fields of their kinds
+func detectDrift(client *kube.Client, cluster, namespace, manifest string, ignored map[string][]string) ([]Drift, error) {
+	drifts := []Drift{}
+	if IsEmptyManifest(manifest) {
+		return drifts, nil
+	}
+
+	infos, err := client.BuildUnstructured(namespace, bytes.NewBufferString(manifest))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		declared, err := objectMap(info.Object)
+		if err != nil {
+			return nil, err
+		}
+		drift := Drift{
+			Cluster: cluster,
+			Kind:    info.Mapping.GroupVersionKind.Kind,
+			Name:    info.Name,
+		}
+
+		if err := info.Get(); err != nil {
+			if errors.IsNotFound(err) {
+				drift.Fields = []string{missingField}
+				drifts = append(drifts, drift)
+				continue
+			}
+			return nil, err
+		}
+		live, err := objectMap(info.Object)
+		if err != nil {
+			return nil, err
+		}
+
+		drift.Fields = withoutFields(diffObject(declared, live), ignored[drift.Kind])
+		if len(drift.Fields) > 0 {
+			drifts = append(drifts, drift)
+		}
+	}
+
+	return drifts, nil
+}
+
+// diffObject returns paths of fields declared in declared object which have other value in live object
+func diffObject(declared, live map[string]interface{}) []string {
+	fields := []string{}
+	for _, key := range sortedKeys(declared) {
+		if driftIgnored[key] {
+			continue
+		}
+		fields = append(fields, diffValue(key, declared[key], live[key])...)
+	}
+	return fields
+}
+
+// withoutFields drops ignored paths and everything under them from fields
+func withoutFields(fields, ignored []string) []string {
+	kept := []string{}
+	for _, field := range fields {
+		skip := false
+		for _, path := range ignored {
+			if field == path || strings.HasPrefix(field, path+".") || strings.HasPrefix(field, path+"[") {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			kept = append(kept, field)
+		}
+	}
+	return kept
+}
+
+func diffValue(path string, declared, live interface{}) []string {
+	if isEmptyValue(declared) {
+		return nil
+	}
+
+	switch d := declared.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return []string{path}
+		}
+		fields := []string{}
+		for _, key := range sortedKeys(d) {
+			fields = append(fields, diffValue(path+"."+key, d[key], l[key])...)
+		}
+		return fields
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(d) {
+			return []string{path}
+		}
+		fields := []string{}
+		for i := range d {
+			fields = append(fields, diffValue(fmt.Sprintf("%s[%d]", path, i), d[i], l[i])...)
+		}
+		return fields
+	default:
+		if fmt.Sprint(declared) != fmt.Sprint(live) {
+			return []string{path}
+		}
+	}
+	return nil
+}
+
+// isEmptyValue tells if declared value is something API server drops instead of storing
+func isEmptyValue(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(value) == 0
+	case []interface{}:
+		return len(value) == 0
+	}
+	return false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// projectLive returns declared object with every declared field holding its live value.
+// Fields which are missing in the live object are left out, lists which changed shape are taken from live object as a whole.
+func projectLive(declared, live interface{}) (interface{}, bool) {
+	switch d := declared.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return live, live != nil
+		}
+		projected := map[string]interface{}{}
+		for key, value := range d {
+			if p, ok := projectLive(value, l[key]); ok {
+				projected[key] = p
+			}
+		}
+		return projected, true
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(d) {
+			return live, live != nil
+		}
+		projected := make([]interface{}, 0, len(d))
+		for i := range d {
+			p, _ := projectLive(d[i], l[i])
+			projected = append(projected, p)
+		}
+		return projected, true
+	}
+	return live, live != nil
+}
+
+// LiveManifest returns manifest of objects from manifest as they are currently seen in the cluster,
+// limited to fields declared in manifest. Objects which do not exist in the cluster are returned as declared.
+func LiveManifest(client *kube.Client, namespace, manifest string) (string, error) {
+	if IsEmptyManifest(manifest) {
+		return manifest, nil
+	}
+
+	infos, err := client.BuildUnstructured(namespace, bytes.NewBufferString(manifest))
+	if err != nil {
+		return "", err
+	}
+
+	result := "---"
+	for _, info := range infos {
+		declared, err := objectMap(info.Object)
+		if err != nil {
+			return "", err
+		}
+
+		object := declared
+		if err := info.Get(); err == nil {
+			live, err := objectMap(info.Object)
+			if err != nil {
+				return "", err
+			}
+			projected, _ := projectLive(declared, live)
+			object = projected.(map[string]interface{})
+		} else if !errors.IsNotFound(err) {
+			return "", err
+		}
+
+		content, err := yaml.Marshal(object)
+		if err != nil {
+			return "", err
+		}
+		result += "\n" + strings.Trim(string(content), "- \t\n") + "\n---"
+	}
+
+	return result, nil
+}
+
+// Reconcile forces state declared in manifest back onto objects which drifted from it.
+// Only declared fields are patched, so fields defaulted or owned by the cluster stay untouched.
+func Reconcile(client *kube.Client, namespace, manifest string, timeout int64) error {
+	live, err := LiveManifest(client, namespace, manifest)
+	if err != nil {
+		return err
+	}
+
+	return client.Update(namespace, bytes.NewBufferString(live), bytes.NewBufferString(manifest), false, false, timeout, false)
+}
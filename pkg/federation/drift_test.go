@@ -0,0 +1,202 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+func toMap(t *testing.T, raw string) map[string]interface{} {
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(raw), &m); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	return m
+}
+
+var declaredDeployment = `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: wp4-wordpress
+  labels:
+    app: wordpress
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: wordpress
+        image: bitnami/wordpress:4.7.3-r0
+        resources: {}
+`
+
+func TestDiffObjectNoDrift(t *testing.T) {
+	live := toMap(t, `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: wp4-wordpress
+  namespace: default
+  resourceVersion: "42"
+  labels:
+    app: wordpress
+spec:
+  replicas: 1
+  strategy:
+    type: RollingUpdate
+  template:
+    spec:
+      containers:
+      - name: wordpress
+        image: bitnami/wordpress:4.7.3-r0
+        imagePullPolicy: IfNotPresent
+status:
+  replicas: 1
+`)
+
+	fields := diffObject(toMap(t, declaredDeployment), live)
+	if len(fields) != 0 {
+		t.Errorf("Expected no drift, got %v", fields)
+	}
+}
+
+func TestDiffObjectDrift(t *testing.T) {
+	live := toMap(t, `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: wp4-wordpress
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: wordpress
+        image: bitnami/wordpress:latest
+`)
+
+	expected := []string{
+		"metadata.labels",
+		"spec.replicas",
+		"spec.template.spec.containers[0].image",
+	}
+	fields := diffObject(toMap(t, declaredDeployment), live)
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected drift in %v, got %v", expected, fields)
+	}
+}
+
+func TestProjectLive(t *testing.T) {
+	live := toMap(t, `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: wp4-wordpress
+  resourceVersion: "42"
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: wordpress
+        image: bitnami/wordpress:latest
+        imagePullPolicy: IfNotPresent
+      - name: sidecar
+        image: busybox
+`)
+
+	expected := toMap(t, `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: wp4-wordpress
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: wordpress
+        image: bitnami/wordpress:latest
+        imagePullPolicy: IfNotPresent
+      - name: sidecar
+        image: busybox
+`)
+
+	projected, ok := projectLive(toMap(t, declaredDeployment), live)
+	if !ok {
+		t.Fatalf("Expected projection to exist")
+	}
+	if !reflect.DeepEqual(projected, expected) {
+		t.Errorf("Projection not as expected. expected:\n%v\ngot:\n%v", expected, projected)
+	}
+}
+
+func TestWithoutFields(t *testing.T) {
+	fields := []string{"metadata.labels", "spec.replicas", "spec.template.spec.containers[0].image"}
+	expected := []string{"metadata.labels", "spec.template.spec.containers[0].image"}
+	if kept := withoutFields(fields, federationScheduled["Deployment"]); !reflect.DeepEqual(kept, expected) {
+		t.Errorf("Expected %v, got %v", expected, kept)
+	}
+	if kept := withoutFields(fields, nil); !reflect.DeepEqual(kept, fields) {
+		t.Errorf("Expected all fields without ignored ones, got %v", kept)
+	}
+}
+
+func TestPlacedByFederation(t *testing.T) {
+	manifest := `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: everywhere
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: pci-only
+  annotations:
+    federation.alpha.kubernetes.io/cluster-selector: '[{"key": "pci", "operator": "In", "values": ["true"]}]'
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: not-in-europe
+  annotations:
+    federation.alpha.kubernetes.io/cluster-selector: '[{"key": "region", "operator": "NotIn", "values": ["europe"]}]'
+---`
+
+	tests := []struct {
+		labels   map[string]string
+		expected []string
+	}{
+		{map[string]string{"pci": "true", "region": "us"}, []string{"everywhere", "pci-only", "not-in-europe"}},
+		{map[string]string{"region": "europe"}, []string{"everywhere"}},
+		{nil, []string{"everywhere", "not-in-europe"}},
+	}
+	for _, test := range tests {
+		placed, err := PlacedByFederation(manifest, &Cluster{Name: "cluster-a", Labels: test.labels})
+		if err != nil {
+			t.Fatalf("Expected no errors, got %v", err)
+		}
+		if names := placedNames(t, placed); !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("Expected %v in cluster with labels %v, got %v", test.expected, test.labels, names)
+		}
+	}
+
+	invalid := "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: broken\n  annotations:\n    federation.alpha.kubernetes.io/cluster-selector: 'pci'\n---"
+	if _, err := PlacedByFederation(invalid, &Cluster{Name: "cluster-a"}); err == nil {
+		t.Errorf("Expected invalid cluster selector to be refused")
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"k8s.io/client-go/kubernetes"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	clientapi "k8s.io/client-go/pkg/api"
+	clientv1 "k8s.io/client-go/pkg/api/v1"
+	clientrest "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+)
+
+// Reasons of events about release operations
+const (
+	EventReasonStarted          = "Started"
+	EventReasonSucceeded        = "Succeeded"
+	EventReasonFailed           = "Failed"
+	EventReasonClusterSucceeded = "ClusterSucceeded"
+	EventReasonClusterFailed    = "ClusterFailed"
+)
+
+// NewEventRecorder returns recorder which sends events to the cluster rudder runs in
+func NewEventRecorder() (record.EventRecorder, error) {
+	config, err := clientrest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(logging.Log.Debugf)
+	broadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: clientset.Core().Events("")})
+	return broadcaster.NewRecorder(clientapi.Scheme, clientv1.EventSource{Component: "rudder"}), nil
+}
+
+// ReleaseEvents records events about operations on a release on its state ConfigMap,
+// where `kubectl describe configmap rudder-<release>` shows them. Nil ReleaseEvents records nothing.
+type ReleaseEvents struct {
+	recorder record.EventRecorder
+	ref      *clientv1.ObjectReference
+}
+
+// Events returns recorder of events about the release, creating its state ConfigMap if needed,
+// so that events have an object to be shown with
+func (s *ReleaseState) Events(recorder record.EventRecorder) (*ReleaseEvents, error) {
+	if recorder == nil {
+		return nil, nil
+	}
+	cm, err := s.Ensure()
+	if err != nil {
+		return nil, err
+	}
+	return &ReleaseEvents{
+		recorder: recorder,
+		ref: &clientv1.ObjectReference{
+			Kind:            "ConfigMap",
+			APIVersion:      "v1",
+			Namespace:       cm.Namespace,
+			Name:            cm.Name,
+			UID:             cm.UID,
+			ResourceVersion: cm.ResourceVersion,
+		},
+	}, nil
+}
+
+// Started records start of operation
+func (e *ReleaseEvents) Started(operation string, revision int32) {
+	if e == nil {
+		return
+	}
+	e.recorder.Eventf(e.ref, clientv1.EventTypeNormal, EventReasonStarted, "%s of revision %d started", operation, revision)
+}
+
+// ClusterFinished records outcome of operation in a single cluster
+func (e *ReleaseEvents) ClusterFinished(operation, cluster string, err error) {
+	if e == nil {
+		return
+	}
+	if err != nil {
+		e.recorder.Eventf(e.ref, clientv1.EventTypeWarning, EventReasonClusterFailed, "%s failed in %s: %v", operation, cluster, err)
+		return
+	}
+	e.recorder.Eventf(e.ref, clientv1.EventTypeNormal, EventReasonClusterSucceeded, "%s succeeded in %s", operation, cluster)
+}
+
+// Finished records final outcome of operation
+func (e *ReleaseEvents) Finished(operation string, revision int32, err error) {
+	if e == nil {
+		return
+	}
+	if err != nil {
+		e.recorder.Eventf(e.ref, clientv1.EventTypeWarning, EventReasonFailed, "%s of revision %d failed: %v", operation, revision, err)
+		return
+	}
+	e.recorder.Eventf(e.ref, clientv1.EventTypeNormal, EventReasonSucceeded, "%s of revision %d succeeded", operation, revision)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+)
+
+func TestReleaseEvents(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	recorder := record.NewFakeRecorder(10)
+
+	events, err := NewReleaseState(client, "wp4").Events(recorder)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	cm, err := client.Core().ConfigMaps(RudderNamespace()).Get("rudder-wp4", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected state ConfigMap to be created for events, got %v", err)
+	}
+	if events.ref.Name != cm.Name || events.ref.Namespace != cm.Namespace || events.ref.UID != cm.UID {
+		t.Errorf("Expected events to refer to the state ConfigMap %+v, got %+v", cm.ObjectMeta, events.ref)
+	}
+	if primary, err := NewReleaseState(client, "wp4").ActivePrimary(); err != nil || primary != "" {
+		t.Errorf("Expected no primary recorded for events, got %q, %v", primary, err)
+	}
+
+	events.Started("upgrade", 2)
+	events.ClusterFinished("update", "cluster-a", nil)
+	events.ClusterFinished("update", "cluster-b", errors.New("timed out"))
+	events.Finished("upgrade", 2, errors.New("cluster-b: timed out"))
+	close(recorder.Events)
+
+	recorded := []string{}
+	for event := range recorder.Events {
+		recorded = append(recorded, event)
+	}
+	expected := []string{
+		"Normal Started upgrade of revision 2 started",
+		"Normal ClusterSucceeded update succeeded in cluster-a",
+		"Warning ClusterFailed update failed in cluster-b: timed out",
+		"Warning Failed upgrade of revision 2 failed: cluster-b: timed out",
+	}
+	if !reflect.DeepEqual(recorded, expected) {
+		t.Errorf("Expected events %v, got %v", expected, recorded)
+	}
+}
+
+func TestReleaseEventsWithoutRecorder(t *testing.T) {
+	events, err := NewReleaseState(fake.NewSimpleClientset(), "wp4").Events(nil)
+	if err != nil || events != nil {
+		t.Fatalf("Expected no events without recorder, got %v, %v", events, err)
+	}
+	//Nil events record nothing and don't panic
+	events.Started("install", 1)
+	events.ClusterFinished("install", "cluster-a", nil)
+	events.Finished("install", 1, nil)
+}
+
+func TestReleaseEventsReferToExistingState(t *testing.T) {
+	state := NewReleaseState(fake.NewSimpleClientset(), "wp4")
+	if err := state.SetActivePrimary("cluster-a"); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	cm, err := state.get()
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	events, err := state.Events(record.NewFakeRecorder(1))
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if events.ref.UID != cm.UID || events.ref.ResourceVersion != cm.ResourceVersion {
+		t.Errorf("Expected events to refer to the state ConfigMap %+v, got %+v", cm.ObjectMeta, events.ref)
+	}
+}
@@ -18,13 +18,16 @@ package federation
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 
-	"google.golang.org/grpc/grpclog"
+	"github.com/Sirupsen/logrus"
 
+	"github.com/Masterminds/sprig"
 	"github.com/ghodss/yaml"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,22 +42,50 @@ import (
 
 	//"k8s.io/helm/pkg/chartutil"
 	"k8s.io/helm/pkg/kube"
+	"k8s.io/helm/pkg/proto/hapi/chart"
 	rudderAPI "k8s.io/helm/pkg/proto/hapi/rudder"
 
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+	"github.com/kubernetes-helm/rudder-federation/pkg/metrics"
 	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
 )
 
-func GetFederatedClusterClients(fed *fedclient.Clientset) (clients []*kube.Client, err error) {
-	clusters, err := fed.Federation().Clusters().List(v1.ListOptions{})
+// Cluster is a member cluster of the federation together with helm client pointed at it
+type Cluster struct {
+	*kube.Client
+	Name   string
+	Host   string
+	Region string
+	Zones  []string
+	Labels map[string]string
+}
+
+type clustersByName []*Cluster
+
+func (c clustersByName) Len() int           { return len(c) }
+func (c clustersByName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c clustersByName) Less(i, j int) bool { return c[i].Name < c[j].Name }
+
+// GetFederatedClusterClients returns all clusters registered in federation, sorted by name
+func GetFederatedClusterClients(fed *fedclient.Clientset) (clusters []*Cluster, err error) {
+	list, err := fed.Federation().Clusters().List(v1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	for _, cluster := range clusters.Items {
-		clients = append(clients, makeClient(cluster))
+	for _, cluster := range list.Items {
+		clusters = append(clusters, &Cluster{
+			Client: makeClient(cluster),
+			Name:   cluster.Name,
+			Host:   cluster.Spec.ServerAddressByClientCIDRs[0].ServerAddress,
+			Region: cluster.Status.Region,
+			Zones:  cluster.Status.Zones,
+			Labels: cluster.Labels,
+		})
 	}
+	sort.Sort(clustersByName(clusters))
 
-	return clients, nil
+	return clusters, nil
 }
 
 func makeClient(cluster federation.Cluster) *kube.Client {
@@ -76,7 +107,7 @@ func makeClient(cluster federation.Cluster) *kube.Client {
 	clientconfig := clientcmd.NewDefaultClientConfig(config, &clientcmd.ConfigOverrides{})
 
 	c := kube.New(clientconfig)
-	c.Log = grpclog.Infof
+	c.Log = logging.ForCluster(logrus.NewEntry(logging.Log), cluster.Name, cluster.Spec.ServerAddressByClientCIDRs[0].ServerAddress).Infof
 
 	return c
 }
@@ -109,7 +140,7 @@ func makeFedClient() *kube.Client {
 	clientconfig := clientcmd.NewDefaultClientConfig(config, &clientcmd.ConfigOverrides{})
 
 	c := kube.New(clientconfig)
-	c.Log = grpclog.Infof
+	c.Log = logging.ForCluster(logrus.NewEntry(logging.Log), "federation", federationConfig.Host).Infof
 
 	return c
 }
@@ -140,6 +171,22 @@ var federationKinds = map[string]bool{
 	"ServiceList":        true,
 }
 
+// FederatedAnnotation set to "true" or "false" overrides whether an object is created in federation or in member clusters
+const FederatedAnnotation = "federation.helm.sh/federated"
+
+// isFederated tells if object goes to federation, by its annotation or by its kind
+func isFederated(o releaseutil.Manifest) bool {
+	if o.Metadata != nil {
+		switch o.Metadata.Annotations[FederatedAnnotation] {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+	}
+	return federationKinds[o.Kind]
+}
+
 func SplitManifestForFed(manifest string) (fed string, local string, err error) {
 
 	objects, err := releaseutil.SplitManifestsWithHeads(manifest)
@@ -151,7 +198,7 @@ func SplitManifestForFed(manifest string) (fed string, local string, err error)
 	local = "---"
 
 	for _, o := range objects {
-		if federationKinds[o.Kind] {
+		if isFederated(o) {
 			fed += "\n" + strings.Trim(o.Content, "- \t\n") + "\n---"
 		} else {
 			local += "\n" + strings.Trim(o.Content, "- \t\n") + "\n---"
@@ -172,13 +219,26 @@ var federationConfig = &rest.Config{
 	Host: "http://example.host",
 }
 
+// federationConfigErr is why federationConfig could not be loaded from federation-credentials, if it couldn't
+var federationConfigErr error
+
+// FederationHost is the address of federation API server
+func FederationHost() string {
+	return federationConfig.Host
+}
+
+// FederationCluster wraps client of federation as a Cluster, for code handling federation and member clusters alike
+func FederationCluster(client *kube.Client) *Cluster {
+	return &Cluster{Client: client, Name: "federation", Host: FederationHost()}
+}
+
 // GetFederationClient uses federationConfig, but it can be overwritten by federation-auth secret within the same namespace
 func GetFederationClient() (*fedclient.Clientset, error) {
 	return fedclient.NewForConfig(federationConfig)
 }
 
-// GetAllClients returns federation clientset, helm federation client and helm clients for all federated clusters
-func GetAllClients() (*fedclient.Clientset, *kube.Client, []*kube.Client, error) {
+// GetAllClients returns federation clientset, helm federation client and all federated clusters
+func GetAllClients() (*fedclient.Clientset, *kube.Client, []*Cluster, error) {
 	fedClientset, err := GetFederationClient()
 	if err != nil {
 		return nil, nil, nil, err
@@ -187,10 +247,22 @@ func GetAllClients() (*fedclient.Clientset, *kube.Client, []*kube.Client, error)
 	fedClient := makeFedClient()
 
 	clients, err := GetFederatedClusterClients(fedClientset)
+	if err == nil {
+		metrics.FederatedClusters.Set(float64(len(clients)))
+	}
 
 	return fedClientset, fedClient, clients, err
 }
 
+// RudderNamespace is the namespace rudder and tiller run in, holding federation credentials and releases
+func RudderNamespace() string {
+	namespace := os.Getenv("RUDDER_NAMESPACE")
+	if namespace == "" {
+		namespace = "kube-system"
+	}
+	return namespace
+}
+
 func populateFederationConfig() error {
 	kubeconfig, err := clientrest.InClusterConfig()
 
@@ -204,12 +276,9 @@ func populateFederationConfig() error {
 		return err
 	}
 
-	namespace := os.Getenv("RUDDER_NAMESPACE")
-	if namespace == "" {
-		namespace = "kube-system"
-	}
+	namespace := RudderNamespace()
 
-	grpclog.Infof("Taking federations credentials from %s namespace", namespace)
+	logging.Log.Infof("Taking federations credentials from %s namespace", namespace)
 
 	cm, err := clientset.Core().ConfigMaps(namespace).Get("federation-credentials", v1.GetOptions{})
 
@@ -227,13 +296,18 @@ func populateFederationConfig() error {
 		federationConfig.KeyData = []byte(cm.Data["keydata"])
 
 		federationConfig.Host = cm.Data["host"]
+	} else {
+		return fmt.Errorf("unknown type %q of federation credentials", cm.Data["type"])
 	}
 
 	return err
 }
 
 func init() {
-	populateFederationConfig()
+	federationConfigErr = populateFederationConfig()
+	if federationConfigErr != nil {
+		logging.Log.Warningf("Cannot load federation credentials: %v", federationConfigErr)
+	}
 }
 
 type Replace struct {
@@ -244,15 +318,12 @@ type ReplaceExtract struct {
 	Replace []Replace `json:"replace"`
 }
 
-func GetReplacements(req *rudderAPI.InstallReleaseRequest) []Replace {
-	raw := req.Release.Config.Raw
+// GetReplacements returns replace rules from release values
+func GetReplacements(config *chart.Config) ([]Replace, error) {
+	raw := rawValues(config)
 	extractor := ReplaceExtract{}
 	err := yaml.Unmarshal([]byte(raw), &extractor)
-	if err != nil {
-		grpclog.Warningln("Error while unmarshalling raw config: ", err)
-	}
-
-	return extractor.Replace
+	return extractor.Replace, err
 }
 
 type DeploymentExtractor struct {
@@ -260,50 +331,58 @@ type DeploymentExtractor struct {
 	Name      string `json:"fed-controller-name"`
 }
 
-func GetFederationControllerDeployment(req *rudderAPI.InstallReleaseRequest) (*extensions.Deployment, error) {
-	raw := req.Release.Config.Raw
+// GetFederationControllerDeployment fetches federation controller Deployment pointed to by release values
+func GetFederationControllerDeployment(config *chart.Config) (*extensions.Deployment, error) {
+	raw := rawValues(config)
 	extractor := DeploymentExtractor{
 		Namespace: "federation-system",
 		Name:      "federation-controller-manager",
 	}
 	err := yaml.Unmarshal([]byte(raw), &extractor)
 	if err != nil {
-		grpclog.Warningln("Error while unmarshalling raw config: ", err)
+		logging.Log.Warningln("Error while unmarshalling raw config: ", err)
 	}
 
 	clientset, err := kube.New(nil).ClientSet()
 	if err != nil {
-		grpclog.Errorf("Cannot initialize Kubernetes connection: %s", err)
+		logging.Log.Errorf("Cannot initialize Kubernetes connection: %s", err)
 		return nil, err
 	}
 
 	dep, err := clientset.Extensions().Deployments(extractor.Namespace).Get(extractor.Name, v1.GetOptions{})
 	if err != nil {
-		grpclog.Errorf("Cannot get deployment %s from ns %s: %v", extractor.Name, extractor.Namespace, err)
+		logging.Log.Errorf("Cannot get deployment %s from ns %s: %v", extractor.Name, extractor.Namespace, err)
 	}
 	return dep, err
 }
 
+// ReplaceWithFederationDeployment renders replacements with template context built from federation controller Deployment only
 func ReplaceWithFederationDeployment(manifest string, replacements []Replace, controller *extensions.Deployment) (string, error) {
+	return ReplaceWithTemplateContext(manifest, replacements, NewTemplateContext(controller, nil, nil))
+}
+
+// ReplaceWithTemplateContext applies replacements to manifest, rendering To part of every replacement with ctx
+func ReplaceWithTemplateContext(manifest string, replacements []Replace, ctx *TemplateContext) (string, error) {
 	for _, rep := range replacements {
 		var tpl bytes.Buffer
-		t, err := template.New("").Parse(rep.To)
+		t, err := template.New("").Funcs(sprig.TxtFuncMap()).Parse(rep.To)
 		if err != nil {
-			grpclog.Errorf("Could not parse template %s: %v", rep.To, err)
+			logging.Log.Errorf("Could not parse template %s: %v", rep.To, err)
 			return manifest, err
 		}
-		err = t.Execute(&tpl, controller)
+		err = t.Execute(&tpl, ctx)
 		if err != nil {
-			grpclog.Errorf("Could not execute template %s: %v", rep.To, err)
+			logging.Log.Errorf("Could not execute template %s: %v", rep.To, err)
 			return manifest, err
 		}
 
 		reg, err := regexp.Compile(rep.From)
 		if err != nil {
-			grpclog.Errorf("Could not compile regex %s: %v", rep.From, err)
+			logging.Log.Errorf("Could not compile regex %s: %v", rep.From, err)
 			return manifest, err
 		}
 
+		metrics.ReplacementMatches.Add(float64(len(reg.FindAllStringIndex(manifest, -1))))
 		manifest = reg.ReplaceAllString(manifest, tpl.String())
 	}
 	return manifest, nil
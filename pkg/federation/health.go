@@ -0,0 +1,43 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CheckFederation tells if rudder can work with the federation: credentials are loaded,
+// federation API server is reachable and member clusters can be listed
+func CheckFederation() error {
+	if federationConfigErr != nil {
+		return fmt.Errorf("federation credentials are not loaded: %v", federationConfigErr)
+	}
+
+	fed, err := GetFederationClient()
+	if err != nil {
+		return err
+	}
+	if _, err := fed.Discovery().ServerVersion(); err != nil {
+		return fmt.Errorf("federation API server %s is not reachable: %v", federationConfig.Host, err)
+	}
+	if _, err := fed.Federation().Clusters().List(v1.ListOptions{}); err != nil {
+		return fmt.Errorf("cannot list federated clusters: %v", err)
+	}
+	return nil
+}
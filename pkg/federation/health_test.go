@@ -0,0 +1,76 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestCheckFederationWithoutCredentials(t *testing.T) {
+	saved := federationConfigErr
+	defer func() { federationConfigErr = saved }()
+
+	federationConfigErr = errors.New(`configmaps "federation-credentials" not found`)
+	err := CheckFederation()
+	if err == nil || !strings.Contains(err.Error(), "federation-credentials") {
+		t.Errorf("Expected error about missing credentials, got %v", err)
+	}
+}
+
+// withFederationAt points federation config at host for the duration of a test
+func withFederationAt(host string) func() {
+	saved, savedErr := federationConfig, federationConfigErr
+	federationConfig, federationConfigErr = &rest.Config{Host: host}, nil
+	return func() { federationConfig, federationConfigErr = saved, savedErr }
+}
+
+func TestCheckFederationUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	defer withFederationAt(server.URL)()
+
+	err := CheckFederation()
+	if err == nil || !strings.Contains(err.Error(), "is not reachable") {
+		t.Errorf("Expected error about unreachable federation, got %v", err)
+	}
+}
+
+func TestCheckFederationReachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/version":
+			w.Write([]byte(`{"major":"1","minor":"7","gitVersion":"v1.7.0"}`))
+		case "/apis/federation/v1beta1/clusters":
+			w.Write([]byte(`{"kind":"ClusterList","apiVersion":"federation/v1beta1","items":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer withFederationAt(server.URL)()
+
+	if err := CheckFederation(); err != nil {
+		t.Errorf("Expected federation to be ready, got %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/ghodss/yaml"
+
+	"k8s.io/helm/pkg/hooks"
+	"k8s.io/helm/pkg/kube"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+// Scopes of hooks
+const (
+	HookScopeFederation = "federation"
+	HookScopeAll        = "all-clusters"
+	HookScopePrimary    = "primary-cluster"
+)
+
+// HookScopeAnnotation overrides scope of a single hook
+const HookScopeAnnotation = "federation.helm.sh/hook-scope"
+
+// Hook delete policies
+const (
+	hookDeletePolicyAnnotation = "helm.sh/hook-delete-policy"
+	HookSucceeded              = "hook-succeeded"
+	HookFailed                 = "hook-failed"
+)
+
+// Hook is an object of the release annotated with helm.sh/hook, which is run on release events instead of being
+// created with the release. Tiller separates hooks from the release manifest into Release.Hooks.
+type Hook struct {
+	Name           string
+	Kind           string
+	Events         []string
+	Weight         int
+	Scope          string
+	DeletePolicies []string
+	Manifest       string
+}
+
+type hooksByWeight []*Hook
+
+func (h hooksByWeight) Len() int           { return len(h) }
+func (h hooksByWeight) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h hooksByWeight) Less(i, j int) bool { return h[i].Weight < h[j].Weight }
+
+type HookScopeExtractor struct {
+	HookScope string `json:"hook-scope"`
+	RunHooks  bool   `json:"run-hooks"`
+}
+
+// GetRunHooks tells if rudder runs hooks of the release. Tiller runs hooks only in its own cluster unless helm
+// is called with --no-hooks, so releases opt in to rudder running them and are then changed with --no-hooks.
+func GetRunHooks(config *chart.Config) bool {
+	extractor := HookScopeExtractor{}
+	err := yaml.Unmarshal([]byte(rawValues(config)), &extractor)
+	if err != nil {
+		logging.Log.Warningln("Error while unmarshalling raw config: ", err)
+	}
+
+	return extractor.RunHooks
+}
+
+// GetHookScope returns scope of hooks of the release, empty when values don't set it
+func GetHookScope(config *chart.Config) (string, error) {
+	extractor := HookScopeExtractor{}
+	err := yaml.Unmarshal([]byte(rawValues(config)), &extractor)
+	if err != nil {
+		return "", err
+	}
+	if extractor.HookScope != "" {
+		return extractor.HookScope, validateHookScope(extractor.HookScope)
+	}
+	return "", nil
+}
+
+func validateHookScope(scope string) error {
+	switch scope {
+	case HookScopeFederation, HookScopeAll, HookScopePrimary:
+		return nil
+	}
+	return fmt.Errorf("unknown hook scope %q, expected %s, %s or %s", scope, HookScopeFederation, HookScopeAll, HookScopePrimary)
+}
+
+// hookEvents maps events of hooks tiller parsed from the chart to their names, hooks of release tests are run
+// by tiller only and are left out
+var hookEvents = map[release.Hook_Event]string{
+	release.Hook_PRE_INSTALL:   hooks.PreInstall,
+	release.Hook_POST_INSTALL:  hooks.PostInstall,
+	release.Hook_PRE_DELETE:    hooks.PreDelete,
+	release.Hook_POST_DELETE:   hooks.PostDelete,
+	release.Hook_PRE_UPGRADE:   hooks.PreUpgrade,
+	release.Hook_POST_UPGRADE:  hooks.PostUpgrade,
+	release.Hook_PRE_ROLLBACK:  hooks.PreRollback,
+	release.Hook_POST_ROLLBACK: hooks.PostRollback,
+}
+
+// ReleaseHooks converts hooks tiller separated from manifest of the release. Hooks without scope use defaultScope,
+// when that is empty too, federated kinds run in federation and the rest in all clusters.
+// Hooks are returned ordered by weight.
+func ReleaseHooks(all []*release.Hook, defaultScope string) ([]*Hook, error) {
+	found := []*Hook{}
+	for _, h := range all {
+		hook, err := newHook(h, defaultScope)
+		if err != nil {
+			return nil, fmt.Errorf("hook %s %s: %v", h.Kind, h.Name, err)
+		}
+		if len(hook.Events) > 0 {
+			found = append(found, hook)
+		}
+	}
+
+	sort.Stable(hooksByWeight(found))
+	return found, nil
+}
+
+func newHook(h *release.Hook, defaultScope string) (*Hook, error) {
+	objects, err := releaseutil.SplitManifestsWithHeads(h.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) != 1 || objects[0].Metadata == nil {
+		return nil, fmt.Errorf("expected a single object with metadata, got %d objects", len(objects))
+	}
+	o := objects[0]
+
+	annotations := o.Metadata.Annotations
+	hook := &Hook{
+		Name:           h.Name,
+		Kind:           h.Kind,
+		Events:         []string{},
+		Weight:         int(h.Weight),
+		DeletePolicies: splitList(annotations[hookDeletePolicyAnnotation]),
+		Scope:          annotations[HookScopeAnnotation],
+		Manifest:       "---\n" + strings.Trim(o.Content, "- \t\n") + "\n---",
+	}
+	for _, e := range h.Events {
+		if event, ok := hookEvents[e]; ok {
+			hook.Events = append(hook.Events, event)
+		}
+	}
+
+	if hook.Scope == "" {
+		hook.Scope = defaultScope
+	}
+	if hook.Scope == "" && isFederated(o) {
+		hook.Scope = HookScopeFederation
+	}
+	if hook.Scope == "" {
+		hook.Scope = HookScopeAll
+	}
+	if err := validateHookScope(hook.Scope); err != nil {
+		return nil, err
+	}
+	if hook.Scope == HookScopeFederation && !isFederated(o) {
+		return nil, fmt.Errorf("%s is not federated, it can't run in federation", o.Kind)
+	}
+	return hook, nil
+}
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (h *Hook) runsOn(event string) bool {
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hook) runsOnAny(events []string) bool {
+	for _, event := range events {
+		if h.runsOn(event) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hook) deletedWhen(policy string) bool {
+	for _, p := range h.DeletePolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// HookRunner runs hooks of a release in federation and member clusters
+type HookRunner struct {
+	Federation *kube.Client
+	Locals     *LocalRules
+	Namespace  string
+	Timeout    int64
+	// Log is the logger of the release, every cluster a hook runs in adds its fields to it
+	Log *logrus.Entry
+}
+
+// Run runs hooks for event one by one in order of their weights, each one in all clusters of its scope at once.
+// Hooks in member clusters get overrides of the cluster applied.
+func (r *HookRunner) Run(all []*Hook, event string) error {
+	for _, hook := range all {
+		if !hook.runsOn(event) {
+			continue
+		}
+
+		targets, err := r.targets(hook)
+		if err != nil {
+			return err
+		}
+
+		errs := make([]error, len(targets))
+		var wg sync.WaitGroup
+		for i, target := range targets {
+			wg.Add(1)
+			go func(i int, target *Cluster) {
+				defer wg.Done()
+				errs[i] = r.runIn(hook, target)
+			}(i, target)
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			if err != nil {
+				return fmt.Errorf("%s hook %s failed in %s: %v", event, hook.Name, targets[i].Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *HookRunner) targets(hook *Hook) ([]*Cluster, error) {
+	switch hook.Scope {
+	case HookScopeFederation:
+		return []*Cluster{FederationCluster(r.Federation)}, nil
+	case HookScopePrimary:
+		if r.Locals.PrimaryLost() {
+			return nil, fmt.Errorf("primary cluster %s is not a member of the federation, fail over to another one first", r.Locals.Placement.Primary)
+		}
+		ordered, err := r.Locals.Placement.Order(r.Locals.Clusters)
+		if err != nil || len(ordered) == 0 {
+			return nil, err
+		}
+		return ordered[:1], nil
+	}
+	return r.Locals.Clusters, nil
+}
+
+// Manifests returns manifests of hooks run on any of events as they are created in federation and in member
+// clusters, keyed by cluster name, so they can be checked before anything runs
+func (r *HookRunner) Manifests(all []*Hook, events ...string) (string, map[string]string, error) {
+	federated := "---"
+	members := map[string]string{}
+	for _, hook := range all {
+		if !hook.runsOnAny(events) {
+			continue
+		}
+		targets, err := r.targets(hook)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, target := range targets {
+			manifest, err := r.manifestIn(hook, target)
+			if err != nil {
+				return "", nil, err
+			}
+			if hook.Scope == HookScopeFederation {
+				federated, err = joinManifests(federated, manifest)
+			} else {
+				members[target.Name], err = joinManifests(members[target.Name], manifest)
+			}
+			if err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	return federated, members, nil
+}
+
+// manifestIn returns manifest of hook with overrides of cluster applied, unless it runs in federation
+func (r *HookRunner) manifestIn(hook *Hook, cluster *Cluster) (string, error) {
+	if hook.Scope == HookScopeFederation {
+		return hook.Manifest, nil
+	}
+	return r.Locals.Overrides.ManifestForCluster(hook.Manifest, cluster, r.Locals.Clusters)
+}
+
+func (r *HookRunner) runIn(hook *Hook, cluster *Cluster) error {
+	manifest, err := r.manifestIn(hook, cluster)
+	if err != nil {
+		return err
+	}
+
+	log := r.Log
+	if log == nil {
+		log = logrus.NewEntry(logging.Log)
+	}
+	log = logging.ForCluster(log, cluster.Name, cluster.Host).WithField("hook", hook.Name)
+
+	log.Info("Running hook")
+	err = cluster.Create(r.Namespace, bytes.NewBufferString(manifest), r.Timeout, false)
+	if err == nil {
+		err = cluster.WatchUntilReady(r.Namespace, bytes.NewBufferString(manifest), r.Timeout, false)
+	}
+
+	if (err == nil && hook.deletedWhen(HookSucceeded)) || (err != nil && hook.deletedWhen(HookFailed)) {
+		if deleteErr := cluster.Delete(r.Namespace, bytes.NewBufferString(manifest)); deleteErr != nil {
+			log.Warningf("Cannot delete hook: %v", deleteErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// testHooks are hooks as tiller separates them from the release manifest
+var testHooks = []*release.Hook{
+	{
+		Name:   "wp4-migrate",
+		Kind:   "Job",
+		Events: []release.Hook_Event{release.Hook_PRE_INSTALL, release.Hook_PRE_UPGRADE},
+		Weight: 5,
+		Manifest: `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: wp4-migrate
+  annotations:
+    helm.sh/hook: pre-install, pre-upgrade
+    helm.sh/hook-weight: "5"
+    helm.sh/hook-delete-policy: hook-succeeded
+`,
+	},
+	{
+		Name:   "wp4-hook-config",
+		Kind:   "ConfigMap",
+		Events: []release.Hook_Event{release.Hook_PRE_INSTALL},
+		Weight: -1,
+		Manifest: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: wp4-hook-config
+  annotations:
+    helm.sh/hook: pre-install
+    helm.sh/hook-weight: "-1"
+`,
+	},
+	{
+		Name:   "wp4-notify",
+		Kind:   "Job",
+		Events: []release.Hook_Event{release.Hook_POST_INSTALL},
+		Manifest: `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: wp4-notify
+  annotations:
+    helm.sh/hook: post-install
+    federation.helm.sh/hook-scope: primary-cluster
+`,
+	},
+	{
+		Name:   "wp4-test",
+		Kind:   "Pod",
+		Events: []release.Hook_Event{release.Hook_RELEASE_TEST_SUCCESS},
+		Manifest: `apiVersion: v1
+kind: Pod
+metadata:
+  name: wp4-test
+  annotations:
+    helm.sh/hook: test-success
+`,
+	},
+}
+
+func TestReleaseHooks(t *testing.T) {
+	hooks, err := ReleaseHooks(testHooks, "")
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	//Hooks of release tests are left to tiller
+	expected := []struct {
+		name   string
+		scope  string
+		events []string
+	}{
+		{"wp4-hook-config", HookScopeFederation, []string{"pre-install"}},
+		{"wp4-notify", HookScopePrimary, []string{"post-install"}},
+		{"wp4-migrate", HookScopeAll, []string{"pre-install", "pre-upgrade"}},
+	}
+	if len(hooks) != len(expected) {
+		t.Fatalf("Expected %d hooks, got %d", len(expected), len(hooks))
+	}
+	for i, e := range expected {
+		if hooks[i].Name != e.name || hooks[i].Scope != e.scope || !reflect.DeepEqual(hooks[i].Events, e.events) {
+			t.Errorf("Expected hook %d to be %s in %s on %v, got %+v", i, e.name, e.scope, e.events, hooks[i])
+		}
+	}
+
+	if hooks[2].Weight != 5 || !strings.Contains(hooks[2].Manifest, "name: wp4-migrate") {
+		t.Errorf("Expected weight and manifest of the release hook, got %+v", hooks[2])
+	}
+	if !hooks[2].runsOn("pre-upgrade") || hooks[2].runsOn("post-install") {
+		t.Errorf("Hook events not as expected, got %v", hooks[2].Events)
+	}
+	if !hooks[2].deletedWhen(HookSucceeded) || hooks[2].deletedWhen(HookFailed) {
+		t.Errorf("Hook delete policies not as expected, got %v", hooks[2].DeletePolicies)
+	}
+}
+
+func TestReleaseHooksDefaultScope(t *testing.T) {
+	hooks, err := ReleaseHooks(testHooks, HookScopePrimary)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	for _, hook := range hooks {
+		if hook.Scope != HookScopePrimary {
+			t.Errorf("Expected hook %s to use scope from values, got %s", hook.Name, hook.Scope)
+		}
+	}
+}
+
+func TestReleaseHooksFederationScopeNeedsFederatedKind(t *testing.T) {
+	if _, err := ReleaseHooks(testHooks, HookScopeFederation); err == nil {
+		t.Errorf("Expected error for Job hook running in federation")
+	}
+}
+
+func TestGetRunHooks(t *testing.T) {
+	if GetRunHooks(nil) {
+		t.Errorf("Expected hooks to be left to tiller by default")
+	}
+	if !GetRunHooks(&chart.Config{Raw: "run-hooks: true\nhook-scope: all-clusters"}) {
+		t.Errorf("Expected rudder to run hooks when asked")
+	}
+}
+
+func TestHookRunnerManifests(t *testing.T) {
+	hooks, err := ReleaseHooks(testHooks, "")
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	runner := &HookRunner{Locals: &LocalRules{Placement: &Placement{Primary: "b"}, Clusters: testClusters("a", "b")}}
+
+	federated, members, err := runner.Manifests(hooks, "pre-install", "post-install")
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if names := placedNames(t, federated); !reflect.DeepEqual(names, []string{"wp4-hook-config"}) {
+		t.Errorf("Expected federation hooks in federation, got %v", names)
+	}
+	if names := placedNames(t, members["a"]); !reflect.DeepEqual(names, []string{"wp4-migrate"}) {
+		t.Errorf("Expected only all-clusters hooks in cluster a, got %v", names)
+	}
+	if names := placedNames(t, members["b"]); !reflect.DeepEqual(names, []string{"wp4-notify", "wp4-migrate"}) {
+		t.Errorf("Expected primary and all-clusters hooks in primary cluster b, got %v", names)
+	}
+
+	_, members, err = runner.Manifests(hooks, "pre-upgrade")
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if names := placedNames(t, members["b"]); !reflect.DeepEqual(names, []string{"wp4-migrate"}) {
+		t.Errorf("Expected only hooks of the event, got %v", names)
+	}
+}
+
+func TestGetHookScope(t *testing.T) {
+	if scope, err := GetHookScope(&chart.Config{Raw: "hook-scope: primary-cluster"}); err != nil || scope != HookScopePrimary {
+		t.Errorf("Expected primary-cluster scope, got %q, %v", scope, err)
+	}
+	if _, err := GetHookScope(&chart.Config{Raw: "hook-scope: everywhere"}); err == nil {
+		t.Errorf("Expected error for unknown scope")
+	}
+}
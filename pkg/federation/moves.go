@@ -0,0 +1,207 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"k8s.io/helm/pkg/kube"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+// Moves are objects which change placement between federation and member clusters on update,
+// because their kind became federated (or stopped being federated) or their annotation changed.
+// Keys are kind/name.
+type Moves struct {
+	ToLocal      []string
+	ToFederation []string
+	// federated versions of objects moving to member clusters, which is what members run now
+	toLocal string
+}
+
+// PlanMoves compares placement of objects in current and target release
+func PlanMoves(federatedCurrent, localCurrent, federatedTarget, localTarget string) (*Moves, error) {
+	localTargetKeys, err := objectKeys(localTarget)
+	if err != nil {
+		return nil, err
+	}
+	federatedTargetKeys, err := objectKeys(federatedTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	moves := &Moves{ToLocal: []string{}, ToFederation: []string{}}
+	moves.toLocal, err = filterObjects(federatedCurrent, func(o releaseutil.Manifest) (bool, error) {
+		return localTargetKeys[objectKey(o)], nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	moves.ToLocal, err = keysOf(moves.toLocal)
+	if err != nil {
+		return nil, err
+	}
+
+	toFederation, err := filterObjects(localCurrent, func(o releaseutil.Manifest) (bool, error) {
+		return federatedTargetKeys[objectKey(o)], nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	moves.ToFederation, err = keysOf(toFederation)
+	return moves, err
+}
+
+// Empty tells if no object moves
+func (m *Moves) Empty() bool {
+	return len(m.ToLocal) == 0 && len(m.ToFederation) == 0
+}
+
+// MemberCurrent adapts current manifest of a member cluster to moves. Objects moving to members were created there
+// by federation, so they are added to the current manifest for the update to take them over instead of failing on
+// existing objects. Objects moving to federation are taken out, so the update leaves them for federation to take over
+// instead of deleting them.
+func (m *Moves) MemberCurrent(current string) (string, error) {
+	if m.Empty() {
+		return current, nil
+	}
+
+	toFederation := map[string]bool{}
+	for _, key := range m.ToFederation {
+		toFederation[key] = true
+	}
+	kept, err := filterObjects(current, func(o releaseutil.Manifest) (bool, error) {
+		return !toFederation[objectKey(o)], nil
+	})
+	if err != nil {
+		return current, err
+	}
+	return joinManifests(kept, m.toLocal)
+}
+
+// FederationCurrent takes objects moving to member clusters out of current manifest of federation, which are
+// handed over to members before the federation is updated
+func (m *Moves) FederationCurrent(current string) (string, error) {
+	if len(m.ToLocal) == 0 {
+		return current, nil
+	}
+
+	toLocal := map[string]bool{}
+	for _, key := range m.ToLocal {
+		toLocal[key] = true
+	}
+	return filterObjects(current, func(o releaseutil.Manifest) (bool, error) {
+		return !toLocal[objectKey(o)], nil
+	})
+}
+
+// HandOver deletes objects moving to member clusters from federation, leaving their copies in member clusters.
+// Objects are annotated not to be deleted from member clusters before they are deleted with orphaned dependents,
+// and HandOver waits until federation lets go of them, so members take them over only once federation is done.
+func (m *Moves) HandOver(client *kube.Client, namespace string, timeout time.Duration) error {
+	if len(m.ToLocal) == 0 {
+		return nil
+	}
+	if err := deleteFromFederation(client, namespace, m.toLocal, false); err != nil {
+		return err
+	}
+	return WaitForDeletion(client, namespace, m.toLocal, timeout)
+}
+
+// TakeBack creates objects moving to member clusters in federation again, when the update after HandOver
+// is reverted. Federation takes over their copies left in member clusters.
+func (m *Moves) TakeBack(client *kube.Client, namespace string, timeout int64) error {
+	if len(m.ToLocal) == 0 {
+		return nil
+	}
+	return client.Create(namespace, bytes.NewBufferString(m.toLocal), timeout, false)
+}
+
+// joinManifests puts objects of all manifests into a single one
+func joinManifests(manifests ...string) (string, error) {
+	result := "---"
+	for _, manifest := range manifests {
+		objects, err := releaseutil.SplitManifestsWithHeads(manifest)
+		if err != nil {
+			return "", err
+		}
+		for _, o := range objects {
+			if !IsEmptyManifest(o.Content) {
+				result += "\n" + strings.Trim(o.Content, "- \t\n") + "\n---"
+			}
+		}
+	}
+	return result, nil
+}
+
+func objectKey(o releaseutil.Manifest) string {
+	name := ""
+	if o.Metadata != nil {
+		name = o.Metadata.Name
+	}
+	return o.Kind + "/" + name
+}
+
+func objectKeys(manifest string) (map[string]bool, error) {
+	objects, err := releaseutil.SplitManifestsWithHeads(manifest)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]bool{}
+	for _, o := range objects {
+		if !IsEmptyManifest(o.Content) {
+			keys[objectKey(o)] = true
+		}
+	}
+	return keys, nil
+}
+
+func keysOf(manifest string) ([]string, error) {
+	objects, err := releaseutil.SplitManifestsWithHeads(manifest)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, o := range objects {
+		if !IsEmptyManifest(o.Content) {
+			keys = append(keys, objectKey(o))
+		}
+	}
+	return keys, nil
+}
+
+// ObjectsOf lists objects of manifests as Kind/name, each of them once
+func ObjectsOf(manifests ...string) ([]string, error) {
+	seen := map[string]bool{}
+	objects := []string{}
+	for _, manifest := range manifests {
+		keys, err := keysOf(manifest)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				objects = append(objects, key)
+			}
+		}
+	}
+	return objects, nil
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func movesObject(kind, name, federated string) string {
+	object := "apiVersion: v1\nkind: " + kind + "\nmetadata:\n  name: " + name + "\n"
+	if federated != "" {
+		object += "  annotations:\n    federation.helm.sh/federated: \"" + federated + "\"\n"
+	}
+	return object
+}
+
+func movesManifest(objects ...string) string {
+	manifest := "---"
+	for _, o := range objects {
+		manifest += "\n" + o + "---"
+	}
+	return manifest
+}
+
+func TestPlanMoves(t *testing.T) {
+	tests := []struct {
+		name         string
+		current      []string
+		target       []string
+		toLocal      []string
+		toFederation []string
+		// names of objects in member cluster's current manifest after moves
+		memberCurrent []string
+		// names of objects in federation's current manifest after moves
+		federationCurrent []string
+	}{
+		{
+			name:              "federated stays federated",
+			current:           []string{movesObject("Deployment", "web", "")},
+			target:            []string{movesObject("Deployment", "web", "")},
+			memberCurrent:     []string{},
+			federationCurrent: []string{"web"},
+		},
+		{
+			name:              "local stays local",
+			current:           []string{movesObject("StatefulSet", "db", "")},
+			target:            []string{movesObject("StatefulSet", "db", "")},
+			memberCurrent:     []string{"db"},
+			federationCurrent: []string{},
+		},
+		{
+			name:              "federated to local",
+			current:           []string{movesObject("Deployment", "web", ""), movesObject("StatefulSet", "db", "")},
+			target:            []string{movesObject("Deployment", "web", "false"), movesObject("StatefulSet", "db", "")},
+			toLocal:           []string{"Deployment/web"},
+			memberCurrent:     []string{"db", "web"},
+			federationCurrent: []string{},
+		},
+		{
+			name:              "local to federated",
+			current:           []string{movesObject("StatefulSet", "db", ""), movesObject("Job", "migrate", "")},
+			target:            []string{movesObject("StatefulSet", "db", "true"), movesObject("Job", "migrate", "")},
+			toFederation:      []string{"StatefulSet/db"},
+			memberCurrent:     []string{"migrate"},
+			federationCurrent: []string{},
+		},
+		{
+			name:              "annotated local back to federated kind",
+			current:           []string{movesObject("Deployment", "web", "false")},
+			target:            []string{movesObject("Deployment", "web", "")},
+			toFederation:      []string{"Deployment/web"},
+			memberCurrent:     []string{},
+			federationCurrent: []string{},
+		},
+		{
+			name:              "both directions at once",
+			current:           []string{movesObject("Deployment", "web", ""), movesObject("StatefulSet", "db", "")},
+			target:            []string{movesObject("Deployment", "web", "false"), movesObject("StatefulSet", "db", "true")},
+			toLocal:           []string{"Deployment/web"},
+			toFederation:      []string{"StatefulSet/db"},
+			memberCurrent:     []string{"web"},
+			federationCurrent: []string{},
+		},
+		{
+			name:              "removed and added objects don't move",
+			current:           []string{movesObject("Deployment", "old", ""), movesObject("Job", "old", "")},
+			target:            []string{movesObject("Deployment", "new", ""), movesObject("Job", "new", "")},
+			memberCurrent:     []string{"old"},
+			federationCurrent: []string{"old"},
+		},
+	}
+
+	for _, test := range tests {
+		federatedCurrent, localCurrent, err := SplitManifestForFed(movesManifest(test.current...))
+		if err != nil {
+			t.Fatalf("%s: expected no errors, got %v", test.name, err)
+		}
+		federatedTarget, localTarget, err := SplitManifestForFed(movesManifest(test.target...))
+		if err != nil {
+			t.Fatalf("%s: expected no errors, got %v", test.name, err)
+		}
+
+		moves, err := PlanMoves(federatedCurrent, localCurrent, federatedTarget, localTarget)
+		if err != nil {
+			t.Fatalf("%s: expected no errors, got %v", test.name, err)
+		}
+
+		if test.toLocal == nil {
+			test.toLocal = []string{}
+		}
+		if test.toFederation == nil {
+			test.toFederation = []string{}
+		}
+		if !reflect.DeepEqual(moves.ToLocal, test.toLocal) || !reflect.DeepEqual(moves.ToFederation, test.toFederation) {
+			t.Errorf("%s: expected moves to local %v and to federation %v, got %v and %v",
+				test.name, test.toLocal, test.toFederation, moves.ToLocal, moves.ToFederation)
+		}
+
+		memberCurrent, err := moves.MemberCurrent(localCurrent)
+		if err != nil {
+			t.Fatalf("%s: expected no errors, got %v", test.name, err)
+		}
+		if names := placedNames(t, memberCurrent); !reflect.DeepEqual(names, test.memberCurrent) {
+			t.Errorf("%s: expected %v in member cluster's current manifest, got %v", test.name, test.memberCurrent, names)
+		}
+
+		federationCurrent, err := moves.FederationCurrent(federatedCurrent)
+		if err != nil {
+			t.Fatalf("%s: expected no errors, got %v", test.name, err)
+		}
+		if names := placedNames(t, federationCurrent); !reflect.DeepEqual(names, test.federationCurrent) {
+			t.Errorf("%s: expected %v in federation's current manifest, got %v", test.name, test.federationCurrent, names)
+		}
+	}
+}
+
+func TestTakeBackAfterHandOver(t *testing.T) {
+	defer func(interval time.Duration) { deletionPollInterval = interval }(deletionPollInterval)
+	deletionPollInterval = 10 * time.Millisecond
+
+	current := movesManifest(movesObject("ConfigMap", "wp4-config", ""))
+	target := movesManifest(movesObject("ConfigMap", "wp4-config", "false"))
+	federatedCurrent, localCurrent, err := SplitManifestForFed(current)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	federatedTarget, localTarget, err := SplitManifestForFed(target)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	moves, err := PlanMoves(federatedCurrent, localCurrent, federatedTarget, localTarget)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	server := newFakeAPIServer(map[string]int{})
+	defer server.Close()
+	if err := moves.HandOver(server.client(), "default", time.Second); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	handedOver := len(server.requests)
+
+	//Reverting the update gives the object back to federation
+	if err := moves.TakeBack(server.client(), "default", 300); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if !reflect.DeepEqual(server.requests[handedOver:], []string{"POST wp4-config"}) {
+		t.Errorf("Expected ConfigMap to be created in federation again after %v, got %v", server.requests[:handedOver], server.requests[handedOver:])
+	}
+	if body := server.bodies["POST wp4-config"]; strings.Contains(body, DeleteFromUnderlyingClustersAnnotation) {
+		t.Errorf("Expected ConfigMap to be created as in the current release, got %s", body)
+	}
+
+	//Without objects moving to member clusters, there is nothing to take back
+	if err := (&Moves{}).TakeBack(nil, "default", 300); err != nil {
+		t.Errorf("Expected no errors, got %v", err)
+	}
+}
+
+func TestObjectsOf(t *testing.T) {
+	current := movesManifest(movesObject("Deployment", "wp4-wordpress", ""), movesObject("Service", "wp4-wordpress", ""))
+	target := movesManifest(movesObject("Service", "wp4-wordpress", ""), movesObject("Secret", "wp4-wordpress", ""))
+
+	objects, err := ObjectsOf(current, target, "")
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	expected := []string{"Deployment/wp4-wordpress", "Service/wp4-wordpress", "Secret/wp4-wordpress"}
+	if !reflect.DeepEqual(objects, expected) {
+		t.Errorf("Expected %v, got %v", expected, objects)
+	}
+}
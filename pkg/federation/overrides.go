@@ -0,0 +1,209 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// ClusterOverride adapts local objects of the release to a cluster. Registry replaces
+// registry of every container image, Patches are applied like release wide patches.
+type ClusterOverride struct {
+	Registry string  `json:"registry"`
+	Patches  []Patch `json:"patches"`
+}
+
+// ClusterOverrides maps cluster names or label selectors (like region=europe) to overrides.
+// Keys naming a member cluster are cluster names, every other key is a label selector.
+type ClusterOverrides map[string]ClusterOverride
+
+type ClusterOverridesExtractor struct {
+	ClusterOverrides ClusterOverrides `json:"clusterOverrides"`
+}
+
+// GetClusterOverrides returns per cluster overrides from release values
+func GetClusterOverrides(config *chart.Config) (ClusterOverrides, error) {
+	extractor := ClusterOverridesExtractor{}
+	err := yaml.Unmarshal([]byte(rawValues(config)), &extractor)
+	if err != nil {
+		return nil, err
+	}
+
+	for key := range extractor.ClusterOverrides {
+		if isSelector(key) {
+			if _, err := labels.Parse(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return extractor.ClusterOverrides, nil
+}
+
+// isSelector tells keys which can only be label selectors, because cluster names can't contain selector operators
+func isSelector(key string) bool {
+	return strings.ContainsAny(key, "=!(),")
+}
+
+// For returns overrides which apply to cluster out of all member clusters. Overrides selected by labels go first,
+// the one for cluster name goes last, so it has the final word.
+func (o ClusterOverrides) For(cluster *Cluster, clusters []*Cluster) []ClusterOverride {
+	names := map[string]bool{cluster.Name: true}
+	for _, c := range clusters {
+		names[c.Name] = true
+	}
+
+	overrides := []ClusterOverride{}
+	for _, key := range o.keys() {
+		if names[key] {
+			continue
+		}
+		selector, err := labels.Parse(key)
+		if err == nil && selector.Matches(labels.Set(cluster.Labels)) {
+			overrides = append(overrides, o[key])
+		}
+	}
+	if override, ok := o[cluster.Name]; ok {
+		overrides = append(overrides, override)
+	}
+	return overrides
+}
+
+// keys returns cluster names and selectors in stable order
+func (o ClusterOverrides) keys() []string {
+	keys := make([]string, 0, len(o))
+	for key := range o {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ManifestForCluster applies all overrides matching cluster out of all member clusters to manifest
+func (o ClusterOverrides) ManifestForCluster(manifest string, cluster *Cluster, clusters []*Cluster) (string, error) {
+	var err error
+	for _, override := range o.For(cluster, clusters) {
+		manifest, err = ApplyPatches(manifest, override.Patches)
+		if err != nil {
+			return manifest, err
+		}
+		manifest, err = ReplaceRegistry(manifest, override.Registry)
+		if err != nil {
+			return manifest, err
+		}
+	}
+	return manifest, nil
+}
+
+// ReplaceRegistry makes images of all containers in manifest come from registry
+func ReplaceRegistry(manifest, registry string) (string, error) {
+	if registry == "" {
+		return manifest, nil
+	}
+
+	return transformObjects(manifest, func(object map[string]interface{}) (map[string]interface{}, error) {
+		if replaceRegistryIn(object, strings.TrimSuffix(registry, "/")) {
+			return object, nil
+		}
+		return nil, nil
+	})
+}
+
+// replaceRegistryIn walks value looking for container lists of pod specs and replaces registry of their images
+func replaceRegistryIn(value interface{}, registry string) bool {
+	changed := false
+	eachContainer(value, func(container map[string]interface{}) {
+		if image, ok := container["image"].(string); ok {
+			container["image"] = withRegistry(image, registry)
+			changed = true
+		}
+	})
+	return changed
+}
+
+// eachContainer walks value looking for container lists of pod specs and calls f with every container, in order of keys
+func eachContainer(value interface{}, f func(container map[string]interface{})) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			child := v[key]
+			if key == "containers" || key == "initContainers" {
+				containers, _ := child.([]interface{})
+				for _, c := range containers {
+					if container, ok := c.(map[string]interface{}); ok {
+						f(container)
+					}
+				}
+				continue
+			}
+			eachContainer(child, f)
+		}
+	case []interface{}:
+		for _, child := range v {
+			eachContainer(child, f)
+		}
+	}
+}
+
+// ImagesIn returns images of all containers of pod specs in object
+func ImagesIn(object interface{}) []string {
+	images := []string{}
+	eachContainer(object, func(container map[string]interface{}) {
+		if image, ok := container["image"].(string); ok {
+			images = append(images, image)
+		}
+	})
+	return images
+}
+
+// DefaultRegistry is where docker pulls images without a registry in their reference from
+const DefaultRegistry = "docker.io"
+
+// splitRegistry separates registry from the rest of image reference, registry is empty when the reference has none.
+// Docker treats the first part of the reference as registry only when it looks like a host name.
+func splitRegistry(image string) (string, string) {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0], parts[1]
+	}
+	return "", image
+}
+
+// withRegistry replaces registry part of image reference
+func withRegistry(image, registry string) string {
+	_, path := splitRegistry(image)
+	return registry + "/" + path
+}
+
+// NormalizeImage returns image reference with the registry docker pulls it from. Images without a registry come
+// from docker.io, single-name images of docker.io from its library, so wordpress is docker.io/library/wordpress.
+func NormalizeImage(image string) string {
+	registry, path := splitRegistry(image)
+	if registry == "" {
+		registry = DefaultRegistry
+	}
+	if registry == DefaultRegistry && !strings.Contains(path, "/") {
+		path = "library/" + path
+	}
+	return registry + "/" + path
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"testing"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+var overridesValues = `clusterOverrides:
+  region=europe:
+    registry: eu.gcr.io/
+  region=europe,tier=prod:
+    patches:
+    - match:
+        kind: Deployment
+      set:
+        spec.replicas: 5
+  paris:
+    patches:
+    - match:
+        kind: Deployment
+      set:
+        spec.replicas: 2
+`
+
+func TestClusterOverridesFor(t *testing.T) {
+	overrides, err := GetClusterOverrides(&chart.Config{Raw: overridesValues})
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	paris := &Cluster{Name: "paris", Labels: map[string]string{"region": "europe", "tier": "prod"}}
+	oregon := &Cluster{Name: "oregon", Labels: map[string]string{"region": "us"}}
+	clusters := []*Cluster{paris, oregon}
+	if o := overrides.For(paris, clusters); len(o) != 3 || o[0].Registry != "eu.gcr.io/" || o[2].Patches[0].Set["spec.replicas"] != float64(2) {
+		t.Errorf("Overrides for paris not as expected, got %+v", o)
+	}
+
+	if o := overrides.For(oregon, clusters); len(o) != 0 {
+		t.Errorf("Expected no overrides for oregon, got %+v", o)
+	}
+}
+
+func TestClusterOverridesBareLabelKey(t *testing.T) {
+	overrides, err := GetClusterOverrides(&chart.Config{Raw: "clusterOverrides:\n  gpu:\n    registry: gpu.gcr.io/\n  paris:\n    registry: eu.gcr.io/\n"})
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	//gpu names no cluster, so it selects clusters having the gpu label
+	paris := &Cluster{Name: "paris", Labels: map[string]string{"gpu": "true"}}
+	oregon := &Cluster{Name: "oregon", Labels: map[string]string{"gpu": "true"}}
+	london := &Cluster{Name: "london", Labels: map[string]string{"paris": "true"}}
+	clusters := []*Cluster{paris, oregon, london}
+
+	if o := overrides.For(oregon, clusters); len(o) != 1 || o[0].Registry != "gpu.gcr.io/" {
+		t.Errorf("Expected override selected by gpu label for oregon, got %+v", o)
+	}
+	if o := overrides.For(paris, clusters); len(o) != 2 || o[1].Registry != "eu.gcr.io/" {
+		t.Errorf("Expected override selected by gpu label and the one of paris last, got %+v", o)
+	}
+	//paris names a cluster, so it isn't a selector of clusters having the paris label
+	if o := overrides.For(london, clusters); len(o) != 0 {
+		t.Errorf("Expected no overrides for london, got %+v", o)
+	}
+}
+
+func TestGetClusterOverridesInvalidSelector(t *testing.T) {
+	_, err := GetClusterOverrides(&chart.Config{Raw: "clusterOverrides:\n  region==!x: {}\n"})
+	if err == nil {
+		t.Errorf("Expected error for invalid selector")
+	}
+}
+
+func TestManifestForCluster(t *testing.T) {
+	overrides, err := GetClusterOverrides(&chart.Config{Raw: overridesValues})
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	paris := &Cluster{Name: "paris", Labels: map[string]string{"region": "europe", "tier": "prod"}}
+	manifest, err := overrides.ManifestForCluster(patchManifest, paris, []*Cluster{paris})
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	deployment := toMap(t, manifestObjects(t, manifest)[1])
+	spec := deployment["spec"].(map[string]interface{})
+	if spec["replicas"] != float64(2) {
+		t.Errorf("Expected replicas to be 2, got %v", spec["replicas"])
+	}
+	container := spec["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})[0].(map[string]interface{})
+	if container["image"] != "eu.gcr.io/bitnami/wordpress:4.7.3-r0" {
+		t.Errorf("Expected image from eu.gcr.io, got %v", container["image"])
+	}
+}
+
+func TestWithRegistry(t *testing.T) {
+	cases := map[string]string{
+		"wordpress":                      "registry.example.com/wordpress",
+		"bitnami/wordpress:4.7.3-r0":     "registry.example.com/bitnami/wordpress:4.7.3-r0",
+		"gcr.io/google_containers/pause": "registry.example.com/google_containers/pause",
+		"localhost:5000/app":             "registry.example.com/app",
+		"localhost/app":                  "registry.example.com/app",
+	}
+
+	for image, expected := range cases {
+		if replaced := withRegistry(image, "registry.example.com"); replaced != expected {
+			t.Errorf("Expected %s to become %s, got %s", image, expected, replaced)
+		}
+	}
+}
+
+func TestNormalizeImage(t *testing.T) {
+	cases := map[string]string{
+		"wordpress":                      "docker.io/library/wordpress",
+		"wordpress:4.8":                  "docker.io/library/wordpress:4.8",
+		"docker.io/wordpress":            "docker.io/library/wordpress",
+		"bitnami/wordpress:4.7.3-r0":     "docker.io/bitnami/wordpress:4.7.3-r0",
+		"gcr.io/google_containers/pause": "gcr.io/google_containers/pause",
+		"localhost:5000/app":             "localhost:5000/app",
+	}
+
+	for image, expected := range cases {
+		if normalized := NormalizeImage(image); normalized != expected {
+			t.Errorf("Expected %s to become %s, got %s", image, expected, normalized)
+		}
+	}
+}
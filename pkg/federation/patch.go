@@ -0,0 +1,320 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/evanphx/json-patch"
+	"github.com/ghodss/yaml"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/kubernetes/pkg/api"
+	_ "k8s.io/kubernetes/pkg/api/install"
+	_ "k8s.io/kubernetes/pkg/apis/apps/install"
+	_ "k8s.io/kubernetes/pkg/apis/batch/install"
+	_ "k8s.io/kubernetes/pkg/apis/extensions/install"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+// Types of patches
+const (
+	PatchJSON      = "json"
+	PatchMerge     = "merge"
+	PatchStrategic = "strategic"
+	PatchSet       = "set"
+)
+
+// Patch is a structured change applied to every object of the release selected by Match.
+// Patch holds JSON patch operations or merge patch document, Set maps paths like
+// spec.template.spec.containers[0].image to values they should be set to.
+type Patch struct {
+	Match PatchMatch             `json:"match"`
+	Type  string                 `json:"type"`
+	Patch interface{}            `json:"patch"`
+	Set   map[string]interface{} `json:"set"`
+}
+
+// PatchMatch selects objects by kind, name and labels. Empty fields match any object.
+type PatchMatch struct {
+	Kind   string            `json:"kind"`
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+}
+
+type PatchExtractor struct {
+	Patches []Patch `json:"patches"`
+}
+
+// GetPatches returns patches listed in release values
+func GetPatches(config *chart.Config) ([]Patch, error) {
+	extractor := PatchExtractor{}
+	err := yaml.Unmarshal([]byte(rawValues(config)), &extractor)
+	return extractor.Patches, err
+}
+
+func (m PatchMatch) matches(object map[string]interface{}) bool {
+	if m.Kind != "" && m.Kind != object["kind"] {
+		return false
+	}
+
+	metadata, _ := object["metadata"].(map[string]interface{})
+	if m.Name != "" && m.Name != metadata["name"] {
+		return false
+	}
+
+	labels, _ := metadata["labels"].(map[string]interface{})
+	for key, value := range m.Labels {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Patch) patchType() string {
+	if p.Type == "" && len(p.Set) > 0 {
+		return PatchSet
+	}
+	if p.Type == "" {
+		return PatchStrategic
+	}
+	return p.Type
+}
+
+// ApplyPatches applies patches to every object of manifest they match, in order patches are listed.
+// Objects which are not matched by any patch are left untouched.
+func ApplyPatches(manifest string, patches []Patch) (string, error) {
+	if len(patches) == 0 {
+		return manifest, nil
+	}
+
+	return transformObjects(manifest, func(object map[string]interface{}) (map[string]interface{}, error) {
+		var patched map[string]interface{}
+		for _, p := range patches {
+			if !p.Match.matches(object) {
+				continue
+			}
+
+			doc, err := json.Marshal(object)
+			if err != nil {
+				return nil, err
+			}
+			doc, err = p.apply(doc, object)
+			if err != nil {
+				return nil, err
+			}
+			patched = map[string]interface{}{}
+			if err := json.Unmarshal(doc, &patched); err != nil {
+				return nil, err
+			}
+			object = patched
+		}
+		return patched, nil
+	})
+}
+
+// transformObjects calls transform for every object of manifest. Objects for which transform returns nil
+// are kept as they are, the rest is replaced with returned object.
+func transformObjects(manifest string, transform func(object map[string]interface{}) (map[string]interface{}, error)) (string, error) {
+	objects, err := releaseutil.SplitManifestsWithHeads(manifest)
+	if err != nil {
+		return manifest, err
+	}
+
+	result := "---"
+	for _, o := range objects {
+		content := strings.Trim(o.Content, "- \t\n")
+
+		object := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(content), &object); err != nil {
+			return manifest, err
+		}
+
+		if object != nil {
+			transformed, err := transform(object)
+			if err != nil {
+				name := ""
+				if o.Metadata != nil {
+					name = o.Metadata.Name
+				}
+				return manifest, fmt.Errorf("error processing %s %s: %v", o.Kind, name, err)
+			}
+			if transformed != nil {
+				data, err := yaml.Marshal(transformed)
+				if err != nil {
+					return manifest, err
+				}
+				content = strings.Trim(string(data), "- \t\n")
+			}
+		}
+
+		result += "\n" + content + "\n---"
+	}
+
+	return result, nil
+}
+
+func (p Patch) apply(doc []byte, object map[string]interface{}) ([]byte, error) {
+	patchType := p.patchType()
+	if patchType == PatchSet {
+		for _, path := range sortedKeys(p.Set) {
+			if err := setPath(object, path, p.Set[path]); err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(object)
+	}
+
+	if p.Patch == nil {
+		return nil, fmt.Errorf("%s patch is empty", patchType)
+	}
+	raw, err := json.Marshal(p.Patch)
+	if err != nil {
+		return nil, err
+	}
+
+	switch patchType {
+	case PatchJSON:
+		patch, err := jsonpatch.DecodePatch(raw)
+		if err != nil {
+			return nil, err
+		}
+		return patch.Apply(doc)
+	case PatchMerge:
+		return jsonpatch.MergePatch(doc, raw)
+	case PatchStrategic:
+		apiVersion, _ := object["apiVersion"].(string)
+		kind, _ := object["kind"].(string)
+		versioned, err := api.Scheme.New(schema.FromAPIVersionAndKind(apiVersion, kind))
+		if err != nil {
+			// Strategic merge needs to know the object structure, which is not there for third party kinds
+			return jsonpatch.MergePatch(doc, raw)
+		}
+		return strategicpatch.StrategicMergePatch(doc, raw, versioned)
+	}
+	return nil, fmt.Errorf("unknown patch type %q", p.Type)
+}
+
+// setPath sets value under path like spec.template.spec.containers[0].image, creating missing objects on the way.
+// Keys containing dots may be written as metadata.annotations["example.com/key"].
+func setPath(object map[string]interface{}, path string, value interface{}) error {
+	segments, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = setIn(object, segments, value)
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %v", path, err)
+	}
+	return nil
+}
+
+func setIn(current interface{}, segments []interface{}, value interface{}) (interface{}, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+
+	switch segment := segments[0].(type) {
+	case string:
+		m, ok := current.(map[string]interface{})
+		if current == nil {
+			m, ok = map[string]interface{}{}, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s is not in an object", segment)
+		}
+		v, err := setIn(m[segment], segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		m[segment] = v
+		return m, nil
+	case int:
+		l, ok := current.([]interface{})
+		if current == nil {
+			ok = true
+		}
+		if !ok {
+			return nil, fmt.Errorf("[%d] is not in a list", segment)
+		}
+		if segment > len(l) {
+			return nil, fmt.Errorf("index %d out of range", segment)
+		}
+		if segment == len(l) {
+			l = append(l, nil)
+		}
+		v, err := setIn(l[segment], segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		l[segment] = v
+		return l, nil
+	}
+	return nil, fmt.Errorf("unknown path segment %v", segments[0])
+}
+
+// parsePath splits path into object keys (strings) and list indexes (ints)
+func parsePath(path string) ([]interface{}, error) {
+	rest := strings.TrimPrefix(path, "$")
+	segments := []interface{}{}
+
+	for len(rest) > 0 {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+		case strings.HasPrefix(rest, `["`):
+			end := strings.Index(rest, `"]`)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated key in path %s", path)
+			}
+			segments = append(segments, rest[2:end])
+			rest = rest[end+2:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in path %s", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index %s in path %s", rest[1:end], path)
+			}
+			segments = append(segments, index)
+			rest = rest[end+1:]
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			segments = append(segments, rest[:end])
+			rest = rest[end:]
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return segments, nil
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+func manifestObjects(t *testing.T, manifest string) []string {
+	objects, err := releaseutil.SplitManifestsWithHeads(manifest)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	contents := []string{}
+	for _, o := range objects {
+		contents = append(contents, strings.Trim(o.Content, "- \t\n"))
+	}
+	return contents
+}
+
+var patchManifest = `---
+apiVersion: v1
+kind: Service
+metadata:
+  name: wp4-mariadb
+spec:
+  ports:
+  - port: 3306
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: wp4-wordpress
+  labels:
+    app: wp4-wordpress
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: wordpress
+        image: bitnami/wordpress:4.7.3-r0
+        env:
+        - name: MARIADB_HOST
+          value: wp4-mariadb
+---`
+
+func TestGetPatches(t *testing.T) {
+	patches, err := GetPatches(&chart.Config{Raw: `patches:
+- match:
+    kind: Deployment
+  set:
+    spec.replicas: 3
+`})
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if len(patches) != 1 || patches[0].Match.Kind != "Deployment" || patches[0].patchType() != PatchSet {
+		t.Errorf("Patches not as expected, got %+v", patches)
+	}
+}
+
+func TestApplyPatchesSet(t *testing.T) {
+	patches := []Patch{
+		Patch{
+			Match: PatchMatch{Kind: "Deployment", Labels: map[string]string{"app": "wp4-wordpress"}},
+			Set: map[string]interface{}{
+				"spec.replicas": 3,
+				"spec.template.spec.containers[0].env[0].value":                               "wp4-mariadb.default.federation.svc.example.com",
+				`spec.template.spec.nodeSelector["failure-domain.beta.kubernetes.io/region"]`: "us-east1",
+			},
+		},
+	}
+
+	patched, err := ApplyPatches(patchManifest, patches)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	expected := `---
+apiVersion: v1
+kind: Service
+metadata:
+  name: wp4-mariadb
+spec:
+  ports:
+  - port: 3306
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  labels:
+    app: wp4-wordpress
+  name: wp4-wordpress
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - env:
+        - name: MARIADB_HOST
+          value: wp4-mariadb.default.federation.svc.example.com
+        image: bitnami/wordpress:4.7.3-r0
+        name: wordpress
+      nodeSelector:
+        failure-domain.beta.kubernetes.io/region: us-east1
+---`
+	if patched != expected {
+		t.Errorf("Patched manifest not as expected. expected:\n%v\ngot:\n%v", expected, patched)
+	}
+}
+
+func TestApplyPatchesJSONAndMerge(t *testing.T) {
+	patches := []Patch{
+		Patch{
+			Match: PatchMatch{Kind: "Service", Name: "wp4-mariadb"},
+			Type:  PatchJSON,
+			Patch: []interface{}{
+				map[string]interface{}{"op": "replace", "path": "/spec/ports/0/port", "value": 3307},
+			},
+		},
+		Patch{
+			Match: PatchMatch{Name: "wp4-mariadb"},
+			Type:  PatchMerge,
+			Patch: map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": map[string]interface{}{"tier": "db"}},
+			},
+		},
+	}
+
+	patched, err := ApplyPatches(patchManifest, patches)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	objects := manifestObjects(t, patched)
+	service := toMap(t, objects[0])
+	if port := service["spec"].(map[string]interface{})["ports"].([]interface{})[0].(map[string]interface{})["port"]; port != float64(3307) {
+		t.Errorf("Expected port to be 3307, got %v", port)
+	}
+	if labels := service["metadata"].(map[string]interface{})["labels"].(map[string]interface{}); labels["tier"] != "db" {
+		t.Errorf("Expected tier label to be db, got %v", labels)
+	}
+	if objects[1] != manifestObjects(t, patchManifest)[1] {
+		t.Errorf("Expected Deployment to stay untouched, got:\n%v", objects[1])
+	}
+}
+
+func TestApplyPatchesStrategic(t *testing.T) {
+	patches := []Patch{
+		Patch{
+			Match: PatchMatch{Kind: "Deployment"},
+			Patch: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"template": map[string]interface{}{
+						"spec": map[string]interface{}{
+							"containers": []interface{}{
+								map[string]interface{}{"name": "wordpress", "image": "registry.example.com/wordpress:4.7.3-r0"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	patched, err := ApplyPatches(patchManifest, patches)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	deployment := toMap(t, manifestObjects(t, patched)[1])
+	containers := deployment["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})
+	container := containers[0].(map[string]interface{})
+	if container["image"] != "registry.example.com/wordpress:4.7.3-r0" {
+		t.Errorf("Expected image to be patched, got %v", container["image"])
+	}
+	if _, ok := container["env"]; !ok {
+		t.Errorf("Expected env to be merged, not replaced")
+	}
+}
+
+func TestApplyPatchesInvalid(t *testing.T) {
+	patches := []Patch{
+		Patch{
+			Match: PatchMatch{Kind: "Deployment"},
+			Set:   map[string]interface{}{"metadata.name[0]": "x"},
+		},
+	}
+
+	if _, err := ApplyPatches(patchManifest, patches); err == nil {
+		t.Errorf("Expected error when setting index of an object")
+	}
+}
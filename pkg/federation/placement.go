@@ -0,0 +1,200 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+// Placement policies of local objects
+const (
+	PlacementAll         = "all"
+	PlacementPrimaryOnly = "primary-cluster-only"
+	placementFirstPrefix = "first-"
+)
+
+// PlacementAnnotation overrides placement policy of a single object
+const PlacementAnnotation = "federation.helm.sh/cluster-placement"
+
+// Placement decides which member clusters get local objects of the release. Policies are keyed
+// by kind (StatefulSet) or kind and name (StatefulSet/wp4-mariadb), objects without policy go everywhere.
+type Placement struct {
+	Primary  string            `json:"primary-cluster"`
+	Policies map[string]string `json:"placement"`
+
+	// primaryLost is set when the recorded primary cluster has left the federation. It keeps the first rank,
+	// so no other cluster gets objects placed in the primary one until failover picks a new primary.
+	primaryLost bool
+}
+
+// GetPlacement returns placement of local objects from release values
+func GetPlacement(config *chart.Config) (*Placement, error) {
+	placement := &Placement{}
+	err := yaml.Unmarshal([]byte(rawValues(config)), placement)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, policy := range placement.Policies {
+		if _, err := placementLimit(policy); err != nil {
+			return nil, fmt.Errorf("placement of %s: %v", key, err)
+		}
+	}
+	return placement, nil
+}
+
+// placementLimit returns how many clusters, counting from the primary one, get objects with policy. Zero means all of them.
+func placementLimit(policy string) (int, error) {
+	switch {
+	case policy == "" || policy == PlacementAll:
+		return 0, nil
+	case policy == PlacementPrimaryOnly:
+		return 1, nil
+	case strings.HasPrefix(policy, placementFirstPrefix):
+		n, err := strconv.Atoi(strings.TrimPrefix(policy, placementFirstPrefix))
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid policy %q, first-N needs a positive N", policy)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("unknown policy %q, expected %s, %s or first-N", policy, PlacementAll, PlacementPrimaryOnly)
+}
+
+// Order returns clusters with the primary one first and the rest ordered by name. Without primary-cluster
+// in values the first cluster by name is the primary one. A lost primary is left out.
+func (p *Placement) Order(clusters []*Cluster) ([]*Cluster, error) {
+	if p.Primary == "" {
+		return clusters, nil
+	}
+
+	ordered := make([]*Cluster, 0, len(clusters))
+	for _, c := range clusters {
+		if c.Name == p.Primary {
+			ordered = append(ordered, c)
+		}
+	}
+	if len(ordered) == 0 && !p.primaryLost {
+		return nil, fmt.Errorf("primary cluster %s is not a member of the federation", p.Primary)
+	}
+	for _, c := range clusters {
+		if c.Name != p.Primary {
+			ordered = append(ordered, c)
+		}
+	}
+	return ordered, nil
+}
+
+// policy returns placement policy of a single object
+func (p *Placement) policy(o releaseutil.Manifest) string {
+	if o.Metadata == nil {
+		return p.Policies[o.Kind]
+	}
+	if policy, ok := o.Metadata.Annotations[PlacementAnnotation]; ok {
+		return policy
+	}
+	if policy, ok := p.Policies[o.Kind+"/"+o.Metadata.Name]; ok {
+		return policy
+	}
+	return p.Policies[o.Kind]
+}
+
+// ManifestForCluster leaves only objects of local manifest which are placed in cluster
+func (p *Placement) ManifestForCluster(manifest string, cluster *Cluster, clusters []*Cluster) (string, error) {
+	ordered, err := p.Order(clusters)
+	if err != nil {
+		return manifest, err
+	}
+	rank := -1
+	for i, c := range ordered {
+		if c.Name == cluster.Name {
+			rank = i
+		}
+	}
+	if rank >= 0 && p.primaryLost {
+		rank++
+	}
+
+	return filterObjects(manifest, func(o releaseutil.Manifest) (bool, error) {
+		limit, err := placementLimit(p.policy(o))
+		return limit == 0 || rank < limit, err
+	})
+}
+
+// filterObjects leaves only objects of manifest for which keep returns true, keeping their order and dropping empty documents
+func filterObjects(manifest string, keep func(o releaseutil.Manifest) (bool, error)) (string, error) {
+	objects, err := releaseutil.SplitManifestsWithHeads(manifest)
+	if err != nil {
+		return manifest, err
+	}
+
+	result := "---"
+	for _, o := range objects {
+		if IsEmptyManifest(o.Content) {
+			continue
+		}
+		kept, err := keep(o)
+		if err != nil {
+			name := ""
+			if o.Metadata != nil {
+				name = o.Metadata.Name
+			}
+			return manifest, fmt.Errorf("error placing %s %s: %v", o.Kind, name, err)
+		}
+		if kept {
+			result += "\n" + strings.Trim(o.Content, "- \t\n") + "\n---"
+		}
+	}
+	return result, nil
+}
+
+// LocalRules adapts local objects of a release to member clusters: placement decides which clusters
+// get an object, cluster overrides then change objects placed in the cluster
+type LocalRules struct {
+	Placement *Placement
+	Overrides ClusterOverrides
+	Clusters  []*Cluster
+}
+
+// GetLocalRules reads placement and cluster overrides from release values
+func GetLocalRules(config *chart.Config, clusters []*Cluster) (*LocalRules, error) {
+	placement, err := GetPlacement(config)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := GetClusterOverrides(config)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalRules{Placement: placement, Overrides: overrides, Clusters: clusters}, nil
+}
+
+// ManifestForCluster returns local objects placed in cluster with overrides of the cluster applied
+func (r *LocalRules) ManifestForCluster(local string, cluster *Cluster) (string, error) {
+	placed, err := r.Placement.ManifestForCluster(local, cluster, r.Clusters)
+	if err != nil {
+		return placed, err
+	}
+	return r.Overrides.ManifestForCluster(placed, cluster, r.Clusters)
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+var placementManifest = `---
+apiVersion: apps/v1beta1
+kind: StatefulSet
+metadata:
+  name: wp4-mariadb
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: wp4-wordpress
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: wp4-migrate
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: wp4-cleanup
+  annotations:
+    federation.helm.sh/cluster-placement: all
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: wp4-config
+---`
+
+var placementValues = `primary-cluster: c
+placement:
+  StatefulSet: primary-cluster-only
+  PersistentVolumeClaim/wp4-wordpress: first-2
+  Job: primary-cluster-only
+`
+
+func placedNames(t *testing.T, manifest string) []string {
+	objects, err := releaseutil.SplitManifestsWithHeads(manifest)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	names := []string{}
+	for _, o := range objects {
+		if o.Metadata != nil {
+			names = append(names, o.Metadata.Name)
+		}
+	}
+	return names
+}
+
+func TestPlacementManifestForCluster(t *testing.T) {
+	placement, err := GetPlacement(&chart.Config{Raw: placementValues})
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	clusters := testClusters("a", "b", "c")
+
+	expected := map[string][]string{
+		"c": {"wp4-mariadb", "wp4-wordpress", "wp4-migrate", "wp4-cleanup", "wp4-config"},
+		"a": {"wp4-wordpress", "wp4-cleanup", "wp4-config"},
+		"b": {"wp4-cleanup", "wp4-config"},
+	}
+	for _, cluster := range clusters {
+		placed, err := placement.ManifestForCluster(placementManifest, cluster, clusters)
+		if err != nil {
+			t.Fatalf("Expected no errors, got %v", err)
+		}
+		if names := placedNames(t, placed); !reflect.DeepEqual(names, expected[cluster.Name]) {
+			t.Errorf("Expected %v in %s, got %v", expected[cluster.Name], cluster.Name, names)
+		}
+	}
+}
+
+func TestPlacementDefaultPrimary(t *testing.T) {
+	placement, err := GetPlacement(&chart.Config{Raw: "placement:\n  StatefulSet: primary-cluster-only\n"})
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	clusters := testClusters("a", "b")
+
+	placed, err := placement.ManifestForCluster(placementManifest, clusters[0], clusters)
+	if err != nil || !strings.Contains(placed, "wp4-mariadb") {
+		t.Errorf("Expected StatefulSet in the first cluster, got %v:\n%s", err, placed)
+	}
+	placed, err = placement.ManifestForCluster(placementManifest, clusters[1], clusters)
+	if err != nil || strings.Contains(placed, "wp4-mariadb") {
+		t.Errorf("Expected no StatefulSet in the second cluster, got %v:\n%s", err, placed)
+	}
+}
+
+func TestPlacementUnknownPrimary(t *testing.T) {
+	placement := &Placement{Primary: "x"}
+	clusters := testClusters("a", "b")
+	if _, err := placement.ManifestForCluster(placementManifest, clusters[0], clusters); err == nil {
+		t.Errorf("Expected error for primary cluster which is not a member")
+	}
+}
+
+func TestGetPlacementInvalidPolicy(t *testing.T) {
+	for _, policy := range []string{"first-0", "first-x", "some"} {
+		if _, err := GetPlacement(&chart.Config{Raw: "placement:\n  Job: " + policy + "\n"}); err == nil {
+			t.Errorf("Expected error for policy %s", policy)
+		}
+	}
+}
+
+func TestPlacementInvalidAnnotation(t *testing.T) {
+	manifest := `---
+kind: Job
+metadata:
+  name: wp4-migrate
+  annotations:
+    federation.helm.sh/cluster-placement: everywhere
+---`
+	clusters := testClusters("a")
+	if _, err := (&Placement{}).ManifestForCluster(manifest, clusters[0], clusters); err == nil {
+		t.Errorf("Expected error for invalid placement annotation")
+	}
+}
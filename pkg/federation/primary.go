@@ -0,0 +1,164 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
+)
+
+const (
+	primaryClusterKey = "primary-cluster"
+	// PrimaryAnnotation is put on federated Services of releases with placement, naming the active primary cluster
+	PrimaryAnnotation = "federation.helm.sh/primary-cluster"
+)
+
+// ReleaseState keeps rudder's own data about a release, which is not part of helm release records,
+// in ConfigMap rudder-<release> in rudder namespace
+type ReleaseState struct {
+	client  internalclientset.Interface
+	release string
+}
+
+// NewReleaseState returns state of release stored using client
+func NewReleaseState(client internalclientset.Interface, release string) *ReleaseState {
+	return &ReleaseState{client: client, release: release}
+}
+
+// Name is the name of ConfigMap holding the state
+func (s *ReleaseState) Name() string {
+	return "rudder-" + s.release
+}
+
+func (s *ReleaseState) get() (*api.ConfigMap, error) {
+	return s.client.Core().ConfigMaps(RudderNamespace()).Get(s.Name(), v1.GetOptions{})
+}
+
+// ActivePrimary returns the primary cluster recorded for release, empty when none was recorded
+func (s *ReleaseState) ActivePrimary() (string, error) {
+	cm, err := s.get()
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return cm.Data[primaryClusterKey], nil
+}
+
+// Ensure creates the state ConfigMap, unless it exists already
+func (s *ReleaseState) Ensure() (*api.ConfigMap, error) {
+	cm, err := s.get()
+	if !apierrors.IsNotFound(err) {
+		return cm, err
+	}
+
+	cm, err = s.client.Core().ConfigMaps(RudderNamespace()).Create(&api.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:   s.Name(),
+			Labels: map[string]string{"OWNER": "RUDDER", "NAME": s.release},
+		},
+	})
+	//Another RPC on the same release may have created it meanwhile
+	if apierrors.IsAlreadyExists(err) {
+		return s.get()
+	}
+	return cm, err
+}
+
+// SetActivePrimary records primary cluster of release
+func (s *ReleaseState) SetActivePrimary(primary string) error {
+	cm, err := s.Ensure()
+	if err != nil {
+		return err
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[primaryClusterKey] = primary
+	_, err = s.client.Core().ConfigMaps(RudderNamespace()).Update(cm)
+	return err
+}
+
+// Delete removes all state of release
+func (s *ReleaseState) Delete() error {
+	err := s.client.Core().ConfigMaps(RudderNamespace()).Delete(s.Name(), &v1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// UsePrimary makes placement use recorded primary cluster instead of the one from values,
+// so that once a release is installed, only failover moves its primary-only objects.
+// A recorded primary which has left the federation is kept as lost, see PrimaryLost.
+func (r *LocalRules) UsePrimary(state *ReleaseState) error {
+	primary, err := state.ActivePrimary()
+	if err != nil || primary == "" {
+		return err
+	}
+
+	r.Placement.Primary = primary
+	r.Placement.primaryLost = true
+	for _, c := range r.Clusters {
+		if c.Name == primary {
+			r.Placement.primaryLost = false
+		}
+	}
+	return nil
+}
+
+// PrimaryLost tells if the recorded primary cluster is not a member of the federation anymore.
+// Objects placed only in the primary cluster are then nowhere until failover.
+func (r *LocalRules) PrimaryLost() bool {
+	return r.Placement.primaryLost
+}
+
+// Primary returns name of the primary cluster of local rules, empty when there are no clusters
+func (r *LocalRules) Primary() (string, error) {
+	if r.Placement.primaryLost {
+		return r.Placement.Primary, nil
+	}
+	ordered, err := r.Placement.Order(r.Clusters)
+	if err != nil || len(ordered) == 0 {
+		return "", err
+	}
+	return ordered[0].Name, nil
+}
+
+// AnnotatePrimary marks federated Services with the primary cluster of releases with placement, for users and tools
+// to tell which cluster runs primary-only objects. Federation doesn't read the annotation, DNS records of federated
+// Services list clusters with healthy endpoints, so they follow primary-only pods when they move on failover.
+func (r *LocalRules) AnnotatePrimary(federated string) (string, error) {
+	if len(r.Placement.Policies) == 0 {
+		return federated, nil
+	}
+	primary, err := r.Primary()
+	if err != nil {
+		return federated, err
+	}
+
+	return transformObjects(federated, func(object map[string]interface{}) (map[string]interface{}, error) {
+		if object["kind"] != "Service" {
+			return nil, nil
+		}
+		return object, setPath(object, `metadata.annotations["`+PrimaryAnnotation+`"]`, primary)
+	})
+}
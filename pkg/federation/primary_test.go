@@ -0,0 +1,132 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func TestReleaseStateActivePrimary(t *testing.T) {
+	state := NewReleaseState(fake.NewSimpleClientset(), "wp4")
+
+	primary, err := state.ActivePrimary()
+	if err != nil || primary != "" {
+		t.Fatalf("Expected no primary recorded, got %q, %v", primary, err)
+	}
+
+	for _, cluster := range []string{"a", "b"} {
+		if err := state.SetActivePrimary(cluster); err != nil {
+			t.Fatalf("Expected no errors, got %v", err)
+		}
+		primary, err = state.ActivePrimary()
+		if err != nil || primary != cluster {
+			t.Errorf("Expected %s to be recorded, got %q, %v", cluster, primary, err)
+		}
+	}
+
+	if err := state.Delete(); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if err := state.Delete(); err != nil {
+		t.Errorf("Expected deleting missing state to succeed, got %v", err)
+	}
+}
+
+func TestLocalRulesUsePrimary(t *testing.T) {
+	state := NewReleaseState(fake.NewSimpleClientset(), "wp4")
+	if err := state.SetActivePrimary("b"); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	locals, err := GetLocalRules(&chart.Config{Raw: placementValues}, testClusters("a", "b", "c"))
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if err := locals.UsePrimary(state); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	if primary, err := locals.Primary(); err != nil || primary != "b" {
+		t.Errorf("Expected recorded primary to win over values, got %q, %v", primary, err)
+	}
+}
+
+func TestAnnotatePrimary(t *testing.T) {
+	locals, err := GetLocalRules(&chart.Config{Raw: placementValues}, testClusters("a", "b", "c"))
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	annotated, err := locals.AnnotatePrimary(patchManifest)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	objects := manifestObjects(t, annotated)
+	if !strings.Contains(objects[0], PrimaryAnnotation+": c") {
+		t.Errorf("Expected Service to be annotated with primary cluster, got:\n%s", objects[0])
+	}
+	if strings.Contains(objects[1], PrimaryAnnotation) {
+		t.Errorf("Expected only Services to be annotated, got:\n%s", objects[1])
+	}
+
+	withoutPlacement := &LocalRules{Placement: &Placement{}, Clusters: testClusters("a")}
+	if unchanged, err := withoutPlacement.AnnotatePrimary(patchManifest); err != nil || unchanged != patchManifest {
+		t.Errorf("Expected manifest without placement to stay the same, got %v:\n%s", err, unchanged)
+	}
+}
+
+func TestLocalRulesLostPrimary(t *testing.T) {
+	state := NewReleaseState(fake.NewSimpleClientset(), "wp4")
+	if err := state.SetActivePrimary("c"); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	//c has left the federation
+	clusters := testClusters("a", "b")
+	locals, err := GetLocalRules(&chart.Config{Raw: placementValues}, clusters)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if err := locals.UsePrimary(state); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	if primary, err := locals.Primary(); err != nil || primary != "c" || !locals.PrimaryLost() {
+		t.Errorf("Expected c to stay the lost primary, got %q, %v", primary, err)
+	}
+
+	//The lost primary keeps its rank, so the rest of clusters keep what they had
+	expected := map[string][]string{
+		"a": {"wp4-wordpress", "wp4-cleanup", "wp4-config"},
+		"b": {"wp4-cleanup", "wp4-config"},
+	}
+	for _, cluster := range clusters {
+		placed, err := locals.ManifestForCluster(placementManifest, cluster)
+		if err != nil {
+			t.Fatalf("Expected no errors, got %v", err)
+		}
+		if names := placedNames(t, placed); !reflect.DeepEqual(names, expected[cluster.Name]) {
+			t.Errorf("Expected %v in %s, got %v", expected[cluster.Name], cluster.Name, names)
+		}
+	}
+}
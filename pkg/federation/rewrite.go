@@ -0,0 +1,94 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"k8s.io/kubernetes/pkg/apis/extensions"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	releaseAPI "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// Rewriter applies replace rules and patches from release values to release manifest.
+// Every RPC touching objects of a release rewrites its manifest, so that install, upgrade,
+// rollback, delete and status all see the same objects.
+type Rewriter struct {
+	Clusters []*Cluster
+	// GetController fetches federation controller Deployment, it is called only for releases with replace rules
+	// or federated DNS enabled
+	GetController func(config *chart.Config) (*extensions.Deployment, error)
+}
+
+// NewRewriter returns Rewriter which renders replacements with data about given clusters
+func NewRewriter(clusters []*Cluster) *Rewriter {
+	return &Rewriter{
+		Clusters:      clusters,
+		GetController: GetFederationControllerDeployment,
+	}
+}
+
+// Rewrite returns manifest of release rewritten with rules from its own values
+func (r *Rewriter) Rewrite(release *releaseAPI.Release) (string, error) {
+	manifest := release.Manifest
+
+	replacements, err := GetReplacements(release.Config)
+	if err != nil {
+		return manifest, err
+	}
+	federatedDNS := GetFederatedDNS(release.Config)
+
+	var ctx *TemplateContext
+	if len(replacements) > 0 || federatedDNS {
+		controller, err := r.GetController(release.Config)
+		if err != nil {
+			return manifest, err
+		}
+		ctx = NewTemplateContext(controller, release, r.Clusters)
+	}
+
+	if len(replacements) > 0 {
+		manifest, err = ReplaceWithTemplateContext(manifest, replacements, ctx)
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	// Service references are rewritten before patches, so patches can still override them
+	if federatedDNS {
+		manifest, err = RewriteServiceReferences(manifest, release.Namespace, ctx.Federation, ctx.Zone)
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	patches, err := GetPatches(release.Config)
+	if err != nil {
+		return manifest, err
+	}
+	return ApplyPatches(manifest, patches)
+}
+
+// RewriteUpdate rewrites both releases of upgrade or rollback, each one with rules from its own values
+func (r *Rewriter) RewriteUpdate(current, target *releaseAPI.Release) (string, string, error) {
+	currentManifest, err := r.Rewrite(current)
+	if err != nil {
+		return "", "", err
+	}
+
+	targetManifest, err := r.Rewrite(target)
+	return currentManifest, targetManifest, err
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	releaseAPI "k8s.io/helm/pkg/proto/hapi/release"
+	rudderAPI "k8s.io/helm/pkg/proto/hapi/rudder"
+)
+
+var rewriteValues = `replace:
+  - from: "(- name: MARIADB_HOST.*\n)( * value: )(.*)(\n)"
+    to: "$1$2$3.{{ .Release.Namespace }}.{{ .Federation }}.svc.{{ .Zone }}\n"
+patches:
+  - match:
+      kind: Deployment
+    set:
+      spec.replicas: 2
+`
+
+func testRewriter() *Rewriter {
+	rewriter := NewRewriter(testClusters("a", "b"))
+	rewriter.GetController = func(config *chart.Config) (*extensions.Deployment, error) {
+		return &deployment, nil
+	}
+	return rewriter
+}
+
+func testRelease(version int32, manifest, values string) *releaseAPI.Release {
+	return &releaseAPI.Release{
+		Name:      "wp4",
+		Namespace: "default",
+		Version:   version,
+		Manifest:  manifest,
+		Config:    &chart.Config{Raw: values},
+	}
+}
+
+func TestRewriteInstall(t *testing.T) {
+	req := &rudderAPI.InstallReleaseRequest{Release: testRelease(1, patchManifest, rewriteValues)}
+
+	rewritten, err := testRewriter().Rewrite(req.Release)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	if !strings.Contains(rewritten, "value: wp4-mariadb.default.federation.svc.example.com") {
+		t.Errorf("Expected MARIADB_HOST to be rewritten to federated name, got:\n%s", rewritten)
+	}
+	if !strings.Contains(rewritten, "replicas: 2") {
+		t.Errorf("Expected Deployment to be patched, got:\n%s", rewritten)
+	}
+}
+
+func TestRewriteInstallAndUpgradeAreIdentical(t *testing.T) {
+	install := &rudderAPI.InstallReleaseRequest{Release: testRelease(1, patchManifest, rewriteValues)}
+	upgrade := &rudderAPI.UpgradeReleaseRequest{
+		Current: testRelease(1, patchManifest, rewriteValues),
+		Target:  testRelease(2, patchManifest, rewriteValues),
+	}
+	rollback := &rudderAPI.RollbackReleaseRequest{
+		Current: testRelease(2, patchManifest, rewriteValues),
+		Target:  testRelease(3, patchManifest, rewriteValues),
+	}
+
+	rewriter := testRewriter()
+	installed, err := rewriter.Rewrite(install.Release)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	for name, releases := range map[string][2]*releaseAPI.Release{
+		"upgrade":  {upgrade.Current, upgrade.Target},
+		"rollback": {rollback.Current, rollback.Target},
+	} {
+		current, target, err := rewriter.RewriteUpdate(releases[0], releases[1])
+		if err != nil {
+			t.Fatalf("Expected no errors in %s, got %v", name, err)
+		}
+		if current != installed {
+			t.Errorf("Expected current manifest of %s to match installed one. expected:\n%s\ngot:\n%s", name, installed, current)
+		}
+		if target != installed {
+			t.Errorf("Expected target manifest of %s to match installed one. expected:\n%s\ngot:\n%s", name, installed, target)
+		}
+	}
+}
+
+func TestRewriteUpdateUsesValuesOfEachRelease(t *testing.T) {
+	current := testRelease(1, patchManifest, rewriteValues)
+	target := testRelease(2, patchManifest, strings.Replace(rewriteValues, "spec.replicas: 2", "spec.replicas: 4", 1))
+
+	currentManifest, targetManifest, err := testRewriter().RewriteUpdate(current, target)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	if !strings.Contains(currentManifest, "replicas: 2") {
+		t.Errorf("Expected current manifest to be patched with current values, got:\n%s", currentManifest)
+	}
+	if !strings.Contains(targetManifest, "replicas: 4") {
+		t.Errorf("Expected target manifest to be patched with target values, got:\n%s", targetManifest)
+	}
+}
+
+func TestRewriteSkipsControllerWithoutReplacements(t *testing.T) {
+	rewriter := testRewriter()
+	rewriter.GetController = func(config *chart.Config) (*extensions.Deployment, error) {
+		return nil, fmt.Errorf("controller should not be fetched")
+	}
+
+	rewritten, err := rewriter.Rewrite(testRelease(1, patchManifest, ""))
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if rewritten != patchManifest {
+		t.Errorf("Expected manifest to stay the same, got:\n%s", rewritten)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+// Rollout describes how an upgrade is rolled out to member clusters.
+// Canary clusters are upgraded first, then the rest of clusters in waves of WavePercent percent of all clusters.
+type Rollout struct {
+	Canary        []string `json:"canary"`
+	CanaryPercent int      `json:"canary-percent"`
+	WavePercent   int      `json:"wave-percent"`
+	Pause         string   `json:"pause"`
+}
+
+type RolloutExtractor struct {
+	Rollout           *Rollout `json:"rollout"`
+	RollbackOnFailure bool     `json:"rollback-on-failure"`
+}
+
+// GetRollout returns rollout strategy from release values or nil if release should be upgraded everywhere at once
+func GetRollout(config *chart.Config) (*Rollout, error) {
+	extractor := RolloutExtractor{}
+	err := yaml.Unmarshal([]byte(rawValues(config)), &extractor)
+	if err != nil || extractor.Rollout == nil {
+		return nil, err
+	}
+
+	if _, err := extractor.Rollout.PauseDuration(); err != nil {
+		return nil, err
+	}
+	if !validPercent(extractor.Rollout.CanaryPercent) || !validPercent(extractor.Rollout.WavePercent) {
+		return nil, fmt.Errorf("rollout percentages have to be between 0 and 100")
+	}
+
+	return extractor.Rollout, nil
+}
+
+// GetRollbackOnFailure tells if clusters which were already upgraded should be reverted to the previous revision
+// when the upgrade fails in any other cluster
+func GetRollbackOnFailure(config *chart.Config) bool {
+	extractor := RolloutExtractor{}
+	err := yaml.Unmarshal([]byte(rawValues(config)), &extractor)
+	if err != nil {
+		logging.Log.Warningln("Error while unmarshalling raw config: ", err)
+	}
+
+	return extractor.RollbackOnFailure
+}
+
+func validPercent(p int) bool {
+	return p >= 0 && p <= 100
+}
+
+// PauseDuration is time to wait between waves
+func (r *Rollout) PauseDuration() (time.Duration, error) {
+	if r.Pause == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(r.Pause)
+}
+
+// Waves splits clusters into consecutive waves. The first wave consists of canary clusters.
+func (r *Rollout) Waves(clusters []*Cluster) ([][]*Cluster, error) {
+	waves := [][]*Cluster{}
+	if len(clusters) == 0 {
+		return waves, nil
+	}
+
+	canary := []*Cluster{}
+	rest := []*Cluster{}
+	if len(r.Canary) > 0 {
+		names := map[string]bool{}
+		for _, name := range r.Canary {
+			names[name] = true
+		}
+		for _, c := range clusters {
+			if names[c.Name] {
+				canary = append(canary, c)
+				delete(names, c.Name)
+			} else {
+				rest = append(rest, c)
+			}
+		}
+		for name := range names {
+			return nil, fmt.Errorf("canary cluster %s is not part of the federation", name)
+		}
+	} else {
+		size := percentOf(r.CanaryPercent, len(clusters))
+		canary = clusters[:size]
+		rest = clusters[size:]
+	}
+	waves = append(waves, canary)
+
+	size := len(rest)
+	if r.WavePercent > 0 {
+		size = percentOf(r.WavePercent, len(clusters))
+	}
+	for len(rest) > 0 {
+		if size > len(rest) {
+			size = len(rest)
+		}
+		waves = append(waves, rest[:size])
+		rest = rest[size:]
+	}
+
+	return waves, nil
+}
+
+// percentOf returns number of clusters making up given percent of all clusters, always at least one
+func percentOf(percent, clusters int) int {
+	size := (percent*clusters + 99) / 100
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// ChangedObjects lists objects which are added, removed or changed between current and target manifest as Kind/name.
+// Federated objects are propagated to all clusters at once, so a rollout can only stage updates which change none.
+func ChangedObjects(current, target string) ([]string, error) {
+	currentObjects, err := objectsByKey(current)
+	if err != nil {
+		return nil, err
+	}
+	targetObjects, err := objectsByKey(target)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	for key, o := range targetObjects {
+		if c, ok := currentObjects[key]; !ok || !reflect.DeepEqual(c, o) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range currentObjects {
+		if _, ok := targetObjects[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func objectsByKey(manifest string) (map[string]map[string]interface{}, error) {
+	objects, err := releaseutil.SplitManifestsWithHeads(manifest)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]map[string]interface{}{}
+	for _, o := range objects {
+		if IsEmptyManifest(o.Content) {
+			continue
+		}
+		object := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(strings.Trim(o.Content, "- \t\n")), &object); err != nil {
+			return nil, err
+		}
+		result[objectKey(o)] = object
+	}
+	return result, nil
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func testClusters(names ...string) []*Cluster {
+	clusters := []*Cluster{}
+	for _, name := range names {
+		clusters = append(clusters, &Cluster{Name: name})
+	}
+	return clusters
+}
+
+func waveNames(waves [][]*Cluster) [][]string {
+	names := [][]string{}
+	for _, wave := range waves {
+		w := []string{}
+		for _, c := range wave {
+			w = append(w, c.Name)
+		}
+		names = append(names, w)
+	}
+	return names
+}
+
+func TestGetRollout(t *testing.T) {
+	rollout, err := GetRollout(&chart.Config{Raw: `rollout:
+  canary: [b]
+  wave-percent: 50
+  pause: 5m
+`})
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	pause, _ := rollout.PauseDuration()
+	if pause != 5*time.Minute {
+		t.Errorf("Expected pause to be 5m, got %v", pause)
+	}
+
+	rollout, err = GetRollout(&chart.Config{Raw: "image: wordpress"})
+	if err != nil || rollout != nil {
+		t.Errorf("Expected no rollout, got %v, %v", rollout, err)
+	}
+
+	_, err = GetRollout(&chart.Config{Raw: "rollout:\n  pause: soon"})
+	if err == nil {
+		t.Errorf("Expected error for invalid pause")
+	}
+}
+
+func TestGetRollbackOnFailure(t *testing.T) {
+	if !GetRollbackOnFailure(&chart.Config{Raw: "rollback-on-failure: true"}) {
+		t.Errorf("Expected rollback on failure to be enabled")
+	}
+	if GetRollbackOnFailure(&chart.Config{Raw: "image: wordpress"}) {
+		t.Errorf("Expected rollback on failure to be disabled by default")
+	}
+}
+
+func TestWavesNamedCanary(t *testing.T) {
+	rollout := Rollout{Canary: []string{"c"}, WavePercent: 50}
+
+	waves, err := rollout.Waves(testClusters("a", "b", "c", "d"))
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	expected := [][]string{{"c"}, {"a", "b"}, {"d"}}
+	if !reflect.DeepEqual(waveNames(waves), expected) {
+		t.Errorf("Expected waves %v, got %v", expected, waveNames(waves))
+	}
+}
+
+func TestWavesCanaryPercent(t *testing.T) {
+	rollout := Rollout{CanaryPercent: 20}
+
+	waves, err := rollout.Waves(testClusters("a", "b", "c", "d", "e", "f"))
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	expected := [][]string{{"a", "b"}, {"c", "d", "e", "f"}}
+	if !reflect.DeepEqual(waveNames(waves), expected) {
+		t.Errorf("Expected waves %v, got %v", expected, waveNames(waves))
+	}
+}
+
+func TestWavesUnknownCanary(t *testing.T) {
+	rollout := Rollout{Canary: []string{"z"}}
+
+	_, err := rollout.Waves(testClusters("a", "b"))
+	if err == nil {
+		t.Errorf("Expected error for canary outside of federation")
+	}
+}
+
+func TestChangedObjects(t *testing.T) {
+	current := `---
+kind: Service
+metadata:
+  name: wp
+spec:
+  type: LoadBalancer
+---
+kind: Deployment
+metadata:
+  name: wp
+spec:
+  replicas: 1
+---
+kind: Secret
+metadata:
+  name: wp
+`
+	//Same objects in different order and formatting are not a change
+	same := `---
+kind: Deployment
+metadata: {name: wp}
+spec: {replicas: 1}
+---
+kind: Secret
+metadata: {name: wp}
+---
+kind: Service
+metadata: {name: wp}
+spec: {type: LoadBalancer}
+`
+	changed, err := ChangedObjects(current, same)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if len(changed) != 0 {
+		t.Errorf("Expected no changes, got %v", changed)
+	}
+
+	target := `---
+kind: Service
+metadata:
+  name: wp
+spec:
+  type: LoadBalancer
+---
+kind: Deployment
+metadata:
+  name: wp
+spec:
+  replicas: 2
+---
+kind: Ingress
+metadata:
+  name: wp
+`
+	changed, err = ChangedObjects(current, target)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if !reflect.DeepEqual(changed, []string{"Deployment/wp", "Ingress/wp", "Secret/wp"}) {
+		t.Errorf("Expected changed Deployment, added Ingress and removed Secret, got %v", changed)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"k8s.io/helm/pkg/kube"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+type AllowClusterScopedExtractor struct {
+	AllowClusterScoped bool `json:"allow-cluster-scoped"`
+}
+
+// GetAllowClusterScoped tells if the release may create cluster-scoped objects, like ClusterRoles, in member clusters
+func GetAllowClusterScoped(config *chart.Config) bool {
+	extractor := AllowClusterScopedExtractor{}
+	err := yaml.Unmarshal([]byte(rawValues(config)), &extractor)
+	if err != nil {
+		logging.Log.Warningln("Error while unmarshalling raw config: ", err)
+	}
+
+	return extractor.AllowClusterScoped
+}
+
+// crdScopes maps group and kind of custom resources defined by CRDs in manifest to their scopes
+func crdScopes(manifest string) (map[schema.GroupKind]meta.RESTScopeName, error) {
+	objects, err := releaseutil.SplitManifestsWithHeads(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := map[schema.GroupKind]meta.RESTScopeName{}
+	for _, o := range objects {
+		if o.Kind != crdKind {
+			continue
+		}
+		spec, err := crdSpecOf(o)
+		if err != nil {
+			return nil, err
+		}
+		if spec.Names.Kind == "" {
+			continue
+		}
+		if spec.Scope == "Cluster" {
+			scopes[spec.GroupKind()] = meta.RESTScopeNameRoot
+		} else {
+			scopes[spec.GroupKind()] = meta.RESTScopeNameNamespace
+		}
+	}
+	return scopes, nil
+}
+
+// groupKind returns group and kind of object, group is the part of apiVersion before the version
+func groupKind(o releaseutil.Manifest) schema.GroupKind {
+	group := ""
+	if i := strings.LastIndex(o.Version, "/"); i >= 0 {
+		group = o.Version[:i]
+	}
+	return schema.GroupKind{Group: group, Kind: o.Kind}
+}
+
+// ClusterScoped lists objects of target which are not namespaced, like ClusterRoles or StorageClasses, as Kind/name.
+// Objects already in current are left out, they were created before and aren't new to the cluster.
+// Scopes of kinds come from discovery of the cluster of client, except custom resources of CRDs in the same manifest,
+// which the cluster doesn't know before the CRDs are created.
+func ClusterScoped(client *kube.Client, namespace, current, target string) ([]string, error) {
+	existing, err := objectKeys(current)
+	if err != nil {
+		return nil, err
+	}
+	scopes, err := crdScopes(target)
+	if err != nil {
+		return nil, err
+	}
+
+	defined := []string{}
+	discovered, err := filterObjects(target, func(o releaseutil.Manifest) (bool, error) {
+		if existing[objectKey(o)] {
+			return false, nil
+		}
+		scope, ok := scopes[groupKind(o)]
+		if ok && scope == meta.RESTScopeNameRoot {
+			defined = append(defined, objectKey(o))
+		}
+		return !ok, nil
+	})
+	if err != nil || IsEmptyManifest(discovered) {
+		return defined, err
+	}
+
+	infos, err := client.BuildUnstructured(namespace, bytes.NewBufferString(discovered))
+	if err != nil {
+		return nil, err
+	}
+	clusterScoped := []string{}
+	for _, info := range infos {
+		if info.Mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+			clusterScoped = append(clusterScoped, info.Mapping.GroupVersionKind.Kind+"/"+info.Name)
+		}
+	}
+	return append(clusterScoped, defined...), nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+func TestCrdScopes(t *testing.T) {
+	manifest := `---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: backups.example.com
+spec:
+  group: example.com
+  version: v1
+  scope: Namespaced
+  names:
+    kind: Backup
+    plural: backups
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: wp4-config
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: policies.example.com
+spec:
+  group: example.com
+  version: v1
+  scope: Cluster
+  names:
+    kind: Policy
+    plural: policies
+`
+	scopes, err := crdScopes(manifest)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	expected := map[schema.GroupKind]meta.RESTScopeName{
+		{Group: "example.com", Kind: "Backup"}: meta.RESTScopeNameNamespace,
+		{Group: "example.com", Kind: "Policy"}: meta.RESTScopeNameRoot,
+	}
+	if !reflect.DeepEqual(scopes, expected) {
+		t.Errorf("Expected %v, got %v", expected, scopes)
+	}
+}
+
+var policyCRD = `apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: policies.example.com
+spec:
+  group: example.com
+  version: v1
+  scope: Cluster
+  names:
+    kind: Policy
+    plural: policies
+`
+
+func TestClusterScopedOnlyNewObjects(t *testing.T) {
+	current := "---\n" + policyCRD + `---
+apiVersion: example.com/v1
+kind: Policy
+metadata:
+  name: wp4-old
+---`
+	target := current + `
+apiVersion: example.com/v1
+kind: Policy
+metadata:
+  name: wp4-new
+---`
+
+	//Every object is either known from current or a custom resource of a CRD in the release, so no discovery is needed
+	objects, err := ClusterScoped(nil, "default", current, target)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if !reflect.DeepEqual(objects, []string{"Policy/wp4-new"}) {
+		t.Errorf("Expected only the new Policy, got %v", objects)
+	}
+}
+
+func TestGroupKind(t *testing.T) {
+	tests := map[string]schema.GroupKind{
+		"v1":                   {Kind: "Policy"},
+		"example.com/v1":       {Group: "example.com", Kind: "Policy"},
+		"other.example.com/v1": {Group: "other.example.com", Kind: "Policy"},
+	}
+	for apiVersion, expected := range tests {
+		o := releaseutil.Manifest{SimpleHead: releaseutil.SimpleHead{Version: apiVersion, Kind: "Policy"}}
+		if gk := groupKind(o); gk != expected {
+			t.Errorf("Expected %v for %s, got %v", expected, apiVersion, gk)
+		}
+	}
+}
+
+func TestGetAllowClusterScoped(t *testing.T) {
+	if GetAllowClusterScoped(nil) {
+		t.Errorf("Expected cluster-scoped objects to be blocked by default")
+	}
+	if !GetAllowClusterScoped(&chart.Config{Raw: "allow-cluster-scoped: true"}) {
+		t.Errorf("Expected cluster-scoped objects to be allowed when asked")
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+
+	releaseAPI "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+const (
+	federationNameAnnotation = "federation.alpha.kubernetes.io/federation-name"
+	federationsAnnotation    = "federations"
+)
+
+// TemplateContext is the data `to` part of replacements is rendered with.
+// Federation controller Deployment is embedded, so templates written against the Deployment alone keep working.
+type TemplateContext struct {
+	*extensions.Deployment
+	Federation string
+	Zone       string
+	Release    ReleaseInfo
+	Clusters   []ClusterInfo
+}
+
+// ReleaseInfo identifies release being processed
+type ReleaseInfo struct {
+	Name      string
+	Namespace string
+	Revision  int32
+}
+
+// ClusterInfo describes a single member cluster of the federation
+type ClusterInfo struct {
+	Name   string
+	Region string
+	Zones  []string
+}
+
+// NewTemplateContext builds template context from federation controller Deployment, release and member clusters.
+// Both release and clusters may be nil.
+func NewTemplateContext(controller *extensions.Deployment, release *releaseAPI.Release, clusters []*Cluster) *TemplateContext {
+	ctx := &TemplateContext{
+		Deployment: controller,
+		Clusters:   []ClusterInfo{},
+	}
+
+	if controller != nil {
+		ctx.Federation, ctx.Zone = federationNameAndZone(controller)
+	}
+
+	if release != nil {
+		ctx.Release = ReleaseInfo{
+			Name:      release.Name,
+			Namespace: release.Namespace,
+			Revision:  release.Version,
+		}
+	}
+
+	for _, c := range clusters {
+		ctx.Clusters = append(ctx.Clusters, ClusterInfo{
+			Name:   c.Name,
+			Region: c.Region,
+			Zones:  c.Zones,
+		})
+	}
+
+	return ctx
+}
+
+// federationNameAndZone reads federation name and its DNS zone from annotations of federation controller Deployment,
+// falling back to flags of the controller manager
+func federationNameAndZone(controller *extensions.Deployment) (name, zone string) {
+	name = controller.Annotations[federationNameAnnotation]
+	if name == "" {
+		name = controllerFlag(controller, "federation-name")
+	}
+
+	for _, federation := range strings.Split(controller.Annotations[federationsAnnotation], ",") {
+		parts := strings.SplitN(federation, "=", 2)
+		if len(parts) == 2 && (name == "" || parts[0] == name) {
+			if name == "" {
+				name = parts[0]
+			}
+			zone = parts[1]
+			break
+		}
+	}
+	if zone == "" {
+		zone = controllerFlag(controller, "zone-name")
+	}
+
+	return name, zone
+}
+
+// controllerFlag returns value of --flag=value passed to any container of the controller Deployment
+func controllerFlag(controller *extensions.Deployment, flag string) string {
+	prefix := "--" + flag + "="
+	for _, container := range controller.Spec.Template.Spec.Containers {
+		for _, args := range [][]string{container.Command, container.Args} {
+			for _, arg := range args {
+				if strings.HasPrefix(arg, prefix) {
+					return strings.TrimPrefix(arg, prefix)
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+
+	releaseAPI "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+func TestNewTemplateContextFromAnnotations(t *testing.T) {
+	release := &releaseAPI.Release{Name: "wp4", Namespace: "blog", Version: 3}
+	clusters := []*Cluster{
+		&Cluster{Name: "east", Region: "us-east1", Zones: []string{"us-east1-b"}},
+	}
+
+	ctx := NewTemplateContext(&deployment, release, clusters)
+
+	if ctx.Federation != "federation" {
+		t.Errorf("Expected federation name to be federation, got %s", ctx.Federation)
+	}
+	if ctx.Zone != "example.com" {
+		t.Errorf("Expected zone to be example.com, got %s", ctx.Zone)
+	}
+	if ctx.Release.Name != "wp4" || ctx.Release.Namespace != "blog" || ctx.Release.Revision != 3 {
+		t.Errorf("Release info not as expected, got %+v", ctx.Release)
+	}
+	if len(ctx.Clusters) != 1 || ctx.Clusters[0].Region != "us-east1" {
+		t.Errorf("Clusters not as expected, got %+v", ctx.Clusters)
+	}
+}
+
+func TestNewTemplateContextFromFlags(t *testing.T) {
+	controller := &extensions.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "federation-controller-manager"},
+		Spec: extensions.DeploymentSpec{
+			Template: api.PodTemplateSpec{
+				Spec: api.PodSpec{
+					Containers: []api.Container{
+						{
+							Command: []string{"/hyperkube", "federation-controller-manager"},
+							Args:    []string{"--federation-name=myfed", "--zone-name=example.org."},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	ctx := NewTemplateContext(controller, nil, nil)
+
+	if ctx.Federation != "myfed" {
+		t.Errorf("Expected federation name to be myfed, got %s", ctx.Federation)
+	}
+	if ctx.Zone != "example.org." {
+		t.Errorf("Expected zone to be example.org., got %s", ctx.Zone)
+	}
+}
+
+func TestReplaceWithTemplateContext(t *testing.T) {
+	release := &releaseAPI.Release{Name: "wp4", Namespace: "blog", Version: 3}
+	replacements := []Replace{
+		Replace{
+			From: `mariadb-root-password: ""`,
+			To:   `mariadb-root-password: {{ .Release.Name | upper }}.{{ .Release.Namespace }}.{{ .Federation }}.svc.{{ .Zone }}`,
+		},
+	}
+
+	replaced, err := ReplaceWithTemplateContext(manifest, replacements, NewTemplateContext(&deployment, release, nil))
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	expected := strings.Replace(manifest, `mariadb-root-password: ""`, `mariadb-root-password: WP4.blog.federation.svc.example.com`, 1)
+	if replaced != expected {
+		t.Logf("expected: %s\nreplaced: %s\n", expected, replaced)
+		t.Fatalf("Replacement not as expected")
+	}
+}
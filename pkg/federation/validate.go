@@ -0,0 +1,164 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+	"github.com/evanphx/json-patch"
+
+	releaseAPI "k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/logging"
+)
+
+// ValidationErrors lists every problem found in rewrite rules of a release
+type ValidationErrors []string
+
+func (e ValidationErrors) Error() string {
+	return "invalid rewrite rules: " + strings.Join(e, "; ")
+}
+
+// orNil returns nil error when no problem was found
+func (e ValidationErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ValidateRelease checks replace rules, patches, cluster overrides, placement and hook scope from release values without talking to any cluster,
+// so that broken values fail the request before anything is changed. All problems are reported at once.
+func ValidateRelease(release *releaseAPI.Release) error {
+	return validateRelease(release, nil)
+}
+
+// ValidateUpdate validates target release of an upgrade or rollback like ValidateRelease does, except that replace rules
+// which current release has as well may match nothing. A chart change dropping an optional object must not lock
+// the release out of upgrades and rollbacks, so such rules are only logged.
+func ValidateUpdate(current, target *releaseAPI.Release) error {
+	known := map[string]bool{}
+	if current != nil {
+		replacements, _ := GetReplacements(current.Config)
+		for _, rep := range replacements {
+			known[rep.From] = true
+		}
+	}
+	return validateRelease(target, known)
+}
+
+// validateRelease validates release, allowing replace rules with regexes in known to match nothing
+func validateRelease(release *releaseAPI.Release, known map[string]bool) error {
+	errs := ValidationErrors{}
+
+	replacements, err := GetReplacements(release.Config)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("cannot read replace rules: %v", err))
+	}
+	errs = append(errs, validateReplacements(release.Manifest, replacements, known)...)
+
+	patches, err := GetPatches(release.Config)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("cannot read patches: %v", err))
+	}
+	errs = append(errs, validatePatches("patches", patches)...)
+
+	overrides, err := GetClusterOverrides(release.Config)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("cannot read cluster overrides: %v", err))
+	}
+	for _, key := range overrides.keys() {
+		errs = append(errs, validatePatches(fmt.Sprintf("clusterOverrides[%q].patches", key), overrides[key].Patches)...)
+	}
+
+	if _, err := GetPlacement(release.Config); err != nil {
+		errs = append(errs, fmt.Sprintf("cannot read placement: %v", err))
+	}
+
+	if _, err := GetHookScope(release.Config); err != nil {
+		errs = append(errs, fmt.Sprintf("cannot read hook scope: %v", err))
+	}
+
+	return errs.orNil()
+}
+
+// validateReplacements compiles every regex and parses every template of replacements. Rules are matched against
+// the manifest as rendered by the chart, a rule matching only text produced by an earlier rule is reported as well.
+// Rules with regexes in known which match nothing are logged instead of reported.
+func validateReplacements(manifest string, replacements []Replace, known map[string]bool) ValidationErrors {
+	errs := ValidationErrors{}
+	for i, rep := range replacements {
+		if _, err := template.New("").Funcs(sprig.TxtFuncMap()).Parse(rep.To); err != nil {
+			errs = append(errs, fmt.Sprintf("replace[%d]: invalid template %q: %v", i, rep.To, err))
+		}
+
+		reg, err := regexp.Compile(rep.From)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("replace[%d]: invalid regex %q: %v", i, rep.From, err))
+			continue
+		}
+		if reg.MatchString(manifest) {
+			continue
+		}
+		if known[rep.From] {
+			logging.Log.Warningf("replace[%d]: regex %q matched nothing", i, rep.From)
+		} else {
+			errs = append(errs, fmt.Sprintf("replace[%d]: regex %q matched nothing", i, rep.From))
+		}
+	}
+	return errs
+}
+
+// validatePatches checks that type, content and paths of every patch make sense
+func validatePatches(field string, patches []Patch) ValidationErrors {
+	errs := ValidationErrors{}
+	for i, p := range patches {
+		prefix := fmt.Sprintf("%s[%d]", field, i)
+
+		switch p.patchType() {
+		case PatchSet:
+			for _, path := range sortedKeys(p.Set) {
+				if _, err := parsePath(path); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %v", prefix, err))
+				}
+			}
+		case PatchJSON, PatchMerge, PatchStrategic:
+			if p.Patch == nil {
+				errs = append(errs, fmt.Sprintf("%s: %s patch is empty", prefix, p.patchType()))
+				continue
+			}
+			raw, err := json.Marshal(p.Patch)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", prefix, err))
+				continue
+			}
+			if p.patchType() == PatchJSON {
+				if _, err := jsonpatch.DecodePatch(raw); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %v", prefix, err))
+				}
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("%s: unknown patch type %q", prefix, p.Type))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateReleaseValid(t *testing.T) {
+	if err := ValidateRelease(testRelease(1, patchManifest, rewriteValues)); err != nil {
+		t.Errorf("Expected no errors, got %v", err)
+	}
+}
+
+func TestValidateReleaseReportsAllProblems(t *testing.T) {
+	values := `replace:
+  - from: "wp4-(mariadb"
+    to: "x"
+  - from: "wp4-mariadb"
+    to: "{{ .Federation "
+  - from: "wp4-postgres"
+    to: "x"
+patches:
+  - type: json
+    patch: {op: replace}
+  - type: unknown
+    patch: {}
+clusterOverrides:
+  paris:
+    patches:
+      - set:
+          "spec.containers[x]": 1
+`
+	err := ValidateRelease(testRelease(1, patchManifest, values))
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("Expected validation errors, got %v", err)
+	}
+
+	expected := []string{
+		`replace[0]: invalid regex "wp4-(mariadb"`,
+		`replace[1]: invalid template "{{ .Federation "`,
+		`replace[2]: regex "wp4-postgres" matched nothing`,
+		`patches[0]: `,
+		`patches[1]: unknown patch type "unknown"`,
+		`clusterOverrides["paris"].patches[0]: invalid index x`,
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(errs), err)
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(errs[i], prefix) {
+			t.Errorf("Expected error %d to start with %q, got %q", i, prefix, errs[i])
+		}
+	}
+}
+
+func TestValidateReleaseInvalidYAML(t *testing.T) {
+	err := ValidateRelease(testRelease(1, patchManifest, "replace: {from: [}"))
+	if err == nil || !strings.Contains(err.Error(), "cannot read replace rules") {
+		t.Errorf("Expected YAML error to be reported, got %v", err)
+	}
+}
+
+func TestValidateUpdateAllowsKnownRulesMatchingNothing(t *testing.T) {
+	current := testRelease(1, patchManifest, `replace:
+  - from: "wp4-postgres"
+    to: "x"
+`)
+	target := testRelease(2, patchManifest, `replace:
+  - from: "wp4-postgres"
+    to: "x"
+  - from: "wp4-redis"
+    to: "x"
+`)
+
+	err := ValidateUpdate(current, target)
+	errs, ok := err.(ValidationErrors)
+	if !ok || len(errs) != 1 || !strings.HasPrefix(errs[0], `replace[1]: regex "wp4-redis" matched nothing`) {
+		t.Errorf("Expected only the new rule to be reported, got %v", err)
+	}
+
+	if err := ValidateUpdate(current, current); err != nil {
+		t.Errorf("Expected rules of the current release to be allowed to match nothing, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging holds the structured logger of rudder and the fields its entries carry
+package logging
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"google.golang.org/grpc/grpclog"
+
+	releaseAPI "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// Fields every log entry about a release or a cluster carries
+const (
+	RPCField       = "rpc"
+	ReleaseField   = "release"
+	NamespaceField = "namespace"
+	RevisionField  = "revision"
+	ClusterField   = "cluster"
+	HostField      = "host"
+	DurationField  = "duration"
+)
+
+// Log is the root logger, entries about releases and clusters are derived from it
+var Log = logrus.New()
+
+// Configure sets level (debug, info, warning, error) and format (json, logfmt) of Log
+// and sends logs of gRPC itself through it too
+func Configure(level, format string) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		Log.Formatter = &logrus.JSONFormatter{}
+	case "logfmt":
+		Log.Formatter = &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}
+	default:
+		return fmt.Errorf("unknown log format %q, use json or logfmt", format)
+	}
+	Log.Level = parsed
+
+	grpclog.SetLogger(Log.WithField("component", "grpc"))
+	return nil
+}
+
+// ForRelease returns logger of rpc about release
+func ForRelease(rpc string, release *releaseAPI.Release) *logrus.Entry {
+	entry := Log.WithField(RPCField, rpc)
+	if release == nil {
+		return entry
+	}
+	return entry.WithFields(logrus.Fields{
+		ReleaseField:   release.Name,
+		NamespaceField: release.Namespace,
+		RevisionField:  release.Version,
+	})
+}
+
+// ForCluster returns logger of entry about cluster at host
+func ForCluster(entry *logrus.Entry, cluster, host string) *logrus.Entry {
+	fields := logrus.Fields{ClusterField: cluster}
+	if host != "" {
+		fields[HostField] = host
+	}
+	return entry.WithFields(fields)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	releaseAPI "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+func TestReleaseAndClusterFields(t *testing.T) {
+	if err := Configure("info", "json"); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	var out bytes.Buffer
+	Log.Out = &out
+
+	release := &releaseAPI.Release{Name: "wp4", Namespace: "blog", Version: 3}
+	ForCluster(ForRelease("upgrade", release), "cluster-a", "https://10.0.0.1").Info("Updating")
+	ForRelease("upgrade", release).Debug("hidden below info level")
+
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a single JSON entry, got %v:\n%s", err, out.String())
+	}
+	expected := map[string]interface{}{
+		"rpc": "upgrade", "release": "wp4", "namespace": "blog", "revision": float64(3),
+		"cluster": "cluster-a", "host": "https://10.0.0.1", "msg": "Updating", "level": "info",
+	}
+	for field, value := range expected {
+		if entry[field] != value {
+			t.Errorf("Expected %s to be %v, got %v", field, value, entry[field])
+		}
+	}
+}
+
+func TestConfigureInvalid(t *testing.T) {
+	if err := Configure("loud", "json"); err == nil {
+		t.Errorf("Expected error for unknown level")
+	}
+	if err := Configure("info", "xml"); err == nil {
+		t.Errorf("Expected error for unknown format")
+	}
+}
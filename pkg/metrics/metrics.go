@@ -0,0 +1,98 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics holds Prometheus metrics of rudder and gRPC server options feeding them
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+)
+
+const namespace = "rudder"
+
+var (
+	// ClusterOperationDuration is the time an operation (install, update, revert, delete) took in a single cluster
+	ClusterOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cluster_operation_duration_seconds",
+		Help:      "Duration of release operations in a single cluster.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"cluster", "operation"})
+
+	// ClusterOperationFailures counts operations which failed in a single cluster
+	ClusterOperationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cluster_operation_failures_total",
+		Help:      "Release operations which failed in a single cluster.",
+	}, []string{"cluster", "operation"})
+
+	// FederatedClusters is the number of member clusters seen last time clients were built
+	FederatedClusters = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "federated_clusters",
+		Help:      "Number of clusters in the federation.",
+	})
+
+	// SplitObjects counts objects installed or upgraded in federation or member clusters
+	SplitObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "split_objects_total",
+		Help:      "Objects of installed and upgraded releases by placement (federation or local).",
+	}, []string{"placement"})
+
+	// ReplacementMatches counts matches of replace rules of all releases. Rules are not labelled, their regular
+	// expressions come from values of every release and would make the number of series unbounded.
+	ReplacementMatches = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "replacement_matches_total",
+		Help:      "Matches of replace rules in release manifests.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(ClusterOperationDuration, ClusterOperationFailures, FederatedClusters, SplitObjects, ReplacementMatches)
+	grpc_prometheus.EnableHandlingTimeHistogram()
+}
+
+// ObserveClusterOperation records duration of operation in cluster which started at start, and its failure if err is set
+func ObserveClusterOperation(cluster, operation string, start time.Time, err error) {
+	ClusterOperationDuration.WithLabelValues(cluster, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ClusterOperationFailures.WithLabelValues(cluster, operation).Inc()
+	}
+}
+
+// Interceptors counting RPCs and their latencies per method
+var (
+	UnaryServerInterceptor  grpc.UnaryServerInterceptor  = grpc_prometheus.UnaryServerInterceptor
+	StreamServerInterceptor grpc.StreamServerInterceptor = grpc_prometheus.StreamServerInterceptor
+)
+
+// Register initializes RPC metrics for all methods of server, so they are exported before the first call
+func Register(server *grpc.Server) {
+	grpc_prometheus.Register(server)
+}
+
+// Handler serves all registered metrics in Prometheus format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
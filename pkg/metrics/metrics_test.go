@@ -0,0 +1,53 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObserveClusterOperation(t *testing.T) {
+	ObserveClusterOperation("cluster-a", "update", time.Now(), nil)
+	ObserveClusterOperation("cluster-a", "update", time.Now(), errors.New("timed out"))
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	for _, expected := range []string{
+		`rudder_cluster_operation_duration_seconds_count{cluster="cluster-a",operation="update"} 2`,
+		`rudder_cluster_operation_failures_total{cluster="cluster-a",operation="update"} 1`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected %s in metrics, got:\n%s", expected, body)
+		}
+	}
+}
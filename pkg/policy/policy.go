@@ -0,0 +1,209 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package policy checks objects of releases against rules set by cluster administrators,
+// before rudder creates or changes anything
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
+
+	"github.com/kubernetes-helm/rudder-federation/pkg/federation"
+	"github.com/kubernetes-helm/rudder-federation/pkg/releaseutil"
+)
+
+const (
+	// ConfigMapName is the ConfigMap in rudder namespace holding the policy
+	ConfigMapName = "rudder-policy"
+	// ConfigMapKey is the key of the policy in the ConfigMap
+	ConfigMapKey = "policy.yaml"
+
+	// FederationName is how violations in federation are reported
+	FederationName = "federation"
+)
+
+// Policy holds rules every release has to follow, empty rules check nothing
+type Policy struct {
+	// DisallowedMemberKinds can't be created in member clusters, federated objects of these kinds are still allowed
+	DisallowedMemberKinds []string `json:"disallowedMemberKinds"`
+	// RequiredLabels have to be set on every object
+	RequiredLabels []string `json:"requiredLabels"`
+	// AllowedRegistries are the only ones container images may come from, like gcr.io or gcr.io/my-project
+	AllowedRegistries []string `json:"allowedRegistries"`
+	// MaxReplicasPerCluster limits replicas of objects in a member cluster. Federation spreads replicas
+	// of federated objects, which may have as many replicas as all member clusters together.
+	MaxReplicasPerCluster int64 `json:"maxReplicasPerCluster"`
+}
+
+// Parse reads policy from YAML
+func Parse(data string) (*Policy, error) {
+	policy := &Policy{}
+	if err := yaml.Unmarshal([]byte(data), policy); err != nil {
+		return nil, fmt.Errorf("cannot read policy: %v", err)
+	}
+	return policy, nil
+}
+
+// Load reads policy from ConfigMap rudder-policy in namespace, returning nil policy when there is none
+func Load(client internalclientset.Interface, namespace string) (*Policy, error) {
+	cm, err := client.Core().ConfigMaps(namespace).Get(ConfigMapName, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Parse(cm.Data[ConfigMapKey])
+}
+
+// Violation is an object breaking a rule of the policy in a cluster
+type Violation struct {
+	Cluster string
+	Object  string
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s: %s", v.Cluster, v.Object, v.Message)
+}
+
+// Violations is the error of a release breaking the policy, listing every violation
+type Violations []Violation
+
+func (v Violations) Error() string {
+	messages := make([]string, len(v))
+	for i, violation := range v {
+		messages[i] = violation.String()
+	}
+	return "release violates policy: " + strings.Join(messages, "; ")
+}
+
+// Check checks federated objects and objects of every member cluster, keyed by cluster name.
+// All violations are returned together as Violations. Nil policy allows everything.
+func (p *Policy) Check(federated string, members map[string]string) error {
+	if p == nil {
+		return nil
+	}
+
+	violations := Violations{}
+	maxFederated := p.MaxReplicasPerCluster * int64(len(members))
+	if err := p.check(FederationName, federated, false, maxFederated, &violations); err != nil {
+		return err
+	}
+
+	clusters := make([]string, 0, len(members))
+	for cluster := range members {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	for _, cluster := range clusters {
+		if err := p.check(cluster, members[cluster], true, p.MaxReplicasPerCluster, &violations); err != nil {
+			return err
+		}
+	}
+
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// check adds violations of objects of manifest in cluster, with replicas limited to maxReplicas unless it's 0
+func (p *Policy) check(cluster, manifest string, member bool, maxReplicas int64, violations *Violations) error {
+	objects, err := releaseutil.SplitManifestsWithHeads(manifest)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range objects {
+		object := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(strings.Trim(o.Content, "- \t\n")), &object); err != nil {
+			return err
+		}
+		if object == nil {
+			continue
+		}
+
+		name := ""
+		if o.Metadata != nil {
+			name = o.Metadata.Name
+		}
+		violated := func(format string, args ...interface{}) {
+			*violations = append(*violations, Violation{Cluster: cluster, Object: o.Kind + "/" + name, Message: fmt.Sprintf(format, args...)})
+		}
+
+		if member && contains(p.DisallowedMemberKinds, o.Kind) {
+			violated("%s is not allowed in member clusters", o.Kind)
+		}
+
+		labels := map[string]interface{}{}
+		if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+			labels, _ = metadata["labels"].(map[string]interface{})
+		}
+		for _, label := range p.RequiredLabels {
+			if _, ok := labels[label]; !ok {
+				violated("label %s is required", label)
+			}
+		}
+
+		if len(p.AllowedRegistries) > 0 {
+			for _, image := range federation.ImagesIn(object) {
+				if !p.allowedImage(image) {
+					violated("image %s is not from an allowed registry", image)
+				}
+			}
+		}
+
+		if spec, ok := object["spec"].(map[string]interface{}); ok && maxReplicas > 0 {
+			//JSON numbers are read as float64
+			if replicas, ok := spec["replicas"].(float64); ok && int64(replicas) > maxReplicas {
+				violated("%d replicas exceed the limit of %d", int64(replicas), maxReplicas)
+			}
+		}
+	}
+	return nil
+}
+
+// allowedImage tells whether image comes from one of allowed registries, an entry allows images
+// of its registry or repository path and everything below it. Images are matched as docker resolves them,
+// see federation.NormalizeImage.
+func (p *Policy) allowedImage(image string) bool {
+	image = federation.NormalizeImage(image)
+	for _, allowed := range p.AllowedRegistries {
+		allowed = strings.TrimSuffix(allowed, "/")
+		if strings.HasPrefix(image, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
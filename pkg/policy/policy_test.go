@@ -0,0 +1,138 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testPolicy = `
+disallowedMemberKinds: [ClusterRoleBinding]
+requiredLabels: [team]
+allowedRegistries: [gcr.io/my-project, docker.io/library]
+maxReplicasPerCluster: 3
+`
+
+const federatedManifest = `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: wp4-wordpress
+  labels:
+    team: blog
+spec:
+  replicas: 6
+  template:
+    spec:
+      containers:
+      - name: wordpress
+        image: gcr.io/my-project/wordpress:4.8
+---
+apiVersion: rbac.authorization.k8s.io/v1beta1
+kind: ClusterRoleBinding
+metadata:
+  name: wp4-admin
+  labels:
+    team: blog
+`
+
+const memberManifest = `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: wp4-mariadb
+  labels:
+    team: blog
+spec:
+  replicas: 4
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: library/busybox
+      containers:
+      - name: mariadb
+        image: quay.io/bitnami/mariadb:10.1
+---
+apiVersion: rbac.authorization.k8s.io/v1beta1
+kind: ClusterRoleBinding
+metadata:
+  name: wp4-admin
+`
+
+func TestCheck(t *testing.T) {
+	policy, err := Parse(testPolicy)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	err = policy.Check(federatedManifest, map[string]string{"cluster-b": memberManifest, "cluster-a": ""})
+	violations, ok := err.(Violations)
+	if !ok {
+		t.Fatalf("Expected violations, got %v", err)
+	}
+
+	expected := Violations{
+		{Cluster: "cluster-b", Object: "Deployment/wp4-mariadb", Message: "image quay.io/bitnami/mariadb:10.1 is not from an allowed registry"},
+		{Cluster: "cluster-b", Object: "Deployment/wp4-mariadb", Message: "4 replicas exceed the limit of 3"},
+		{Cluster: "cluster-b", Object: "ClusterRoleBinding/wp4-admin", Message: "ClusterRoleBinding is not allowed in member clusters"},
+		{Cluster: "cluster-b", Object: "ClusterRoleBinding/wp4-admin", Message: "label team is required"},
+	}
+	if !reflect.DeepEqual(violations, expected) {
+		t.Errorf("Expected %v, got %v", expected, violations)
+	}
+}
+
+func TestCheckWithoutPolicy(t *testing.T) {
+	var policy *Policy
+	if err := policy.Check(federatedManifest, map[string]string{"cluster-a": memberManifest}); err != nil {
+		t.Errorf("Expected no policy to allow everything, got %v", err)
+	}
+}
+
+func TestAllowedImage(t *testing.T) {
+	policy := &Policy{AllowedRegistries: []string{"gcr.io/my-project/", "localhost:5000"}}
+	tests := map[string]bool{
+		"gcr.io/my-project/wordpress:4.8": true,
+		"gcr.io/my-project-2/wordpress":   false,
+		"gcr.io/other/wordpress":          false,
+		"localhost:5000/wordpress":        true,
+		"wordpress":                       false,
+	}
+	for image, allowed := range tests {
+		if policy.allowedImage(image) != allowed {
+			t.Errorf("Expected %s allowed: %v", image, allowed)
+		}
+	}
+}
+
+func TestAllowedImageOfficialImages(t *testing.T) {
+	policy := &Policy{AllowedRegistries: []string{"docker.io/library"}}
+	tests := map[string]bool{
+		"wordpress:4.8":           true,
+		"docker.io/wordpress":     true,
+		"library/wordpress":       true,
+		"bitnami/wordpress":       false,
+		"docker.io/bitnami/nginx": false,
+	}
+	for image, allowed := range tests {
+		if policy.allowedImage(image) != allowed {
+			t.Errorf("Expected %s allowed: %v", image, allowed)
+		}
+	}
+}
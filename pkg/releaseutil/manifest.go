@@ -18,6 +18,8 @@ package releaseutil
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/ghodss/yaml"
@@ -52,20 +54,38 @@ func SplitManifests(bigfile string) map[string]string {
 	return res
 }
 
+// BySplitManifestsOrder sorts names of manifests returned by SplitManifests in order they appeared in the file
+type BySplitManifestsOrder []string
+
+func (a BySplitManifestsOrder) Len() int      { return len(a) }
+func (a BySplitManifestsOrder) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a BySplitManifestsOrder) Less(i, j int) bool {
+	anum, _ := strconv.Atoi(strings.TrimPrefix(a[i], "manifest-"))
+	bnum, _ := strconv.Atoi(strings.TrimPrefix(a[j], "manifest-"))
+	return anum < bnum
+}
+
 // Manifest reperestens a single manifest content with SimpleHead added for additional metadata
 type Manifest struct {
 	SimpleHead
 	Content string
 }
 
-// SplitManifestsWithHeads
+// SplitManifestsWithHeads splits manifests like SplitManifests does, keeping order they appeared in the file
 func SplitManifestsWithHeads(bigfile string) ([]Manifest, error) {
 	raws := SplitManifests(bigfile)
 
+	names := make([]string, 0, len(raws))
+	for name := range raws {
+		names = append(names, name)
+	}
+	sort.Sort(BySplitManifestsOrder(names))
+
 	result := make([]Manifest, 0, len(raws))
 	var err error
 
-	for _, raw := range raws {
+	for _, name := range names {
+		raw := raws[name]
 		var head SimpleHead
 		err = yaml.Unmarshal([]byte(raw), &head)
 
@@ -17,6 +17,7 @@ limitations under the License.
 package releaseutil
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -61,3 +62,22 @@ metadata:
 		}
 	}
 }
+
+func TestSplitManifestsWithHeadsKeepsOrder(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"}
+	docs := make([]string, 0, len(names))
+	for _, name := range names {
+		docs = append(docs, "kind: TestKind\napiVersion: v1\nmetadata:\n  name: "+name)
+	}
+
+	manifests, err := SplitManifestsWithHeads(strings.Join(docs, "\n---\n"))
+	if err != nil {
+		t.Errorf("Expected error to be nil, got %s", err)
+	}
+
+	for i, m := range manifests {
+		if m.SimpleHead.Metadata.Name != names[i] {
+			t.Errorf("Expected manifest %d to be %s, got %s", i, names[i], m.SimpleHead.Metadata.Name)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// fileSpan is a span written by FileRecorder
+type fileSpan struct {
+	TraceID   string                 `json:"trace_id"`
+	SpanID    string                 `json:"span_id"`
+	ParentID  string                 `json:"parent_id,omitempty"`
+	Operation string                 `json:"operation"`
+	Start     time.Time              `json:"start"`
+	Duration  float64                `json:"duration"`
+	Tags      map[string]interface{} `json:"tags,omitempty"`
+}
+
+// FileRecorder writes spans to a file as JSON, one span per line
+type FileRecorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileRecorder appends spans to file at path, "-" stands for stdout
+func NewFileRecorder(path string) (*FileRecorder, error) {
+	if path == "-" {
+		return &FileRecorder{file: os.Stdout}, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileRecorder{file: file}, nil
+}
+
+// RecordSpan implements basictracer.SpanRecorder
+func (r *FileRecorder) RecordSpan(span basictracer.RawSpan) {
+	line, err := json.Marshal(fileSpan{
+		TraceID:   spanID(span.Context.TraceID),
+		SpanID:    spanID(span.Context.SpanID),
+		ParentID:  parentID(span.ParentSpanID),
+		Operation: span.Operation,
+		Start:     span.Start,
+		Duration:  span.Duration.Seconds(),
+		Tags:      span.Tags,
+	})
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.file.Write(append(line, '\n'))
+}
+
+// Close closes the file
+func (r *FileRecorder) Close() error {
+	if r.file == os.Stdout {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// otlpFlushPeriod is how often OTLPRecorder sends recorded spans
+var otlpFlushPeriod = 5 * time.Second
+
+// OTLPRecorder sends spans in batches to an OpenTelemetry collector over OTLP/HTTP with JSON encoding
+type OTLPRecorder struct {
+	endpoint string
+	client   *http.Client
+
+	mu    sync.Mutex
+	spans []basictracer.RawSpan
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewOTLPRecorder sends spans to collector at endpoint, like http://otel-collector:4318
+func NewOTLPRecorder(endpoint string) (*OTLPRecorder, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("OTLP endpoint is required")
+	}
+	r := &OTLPRecorder{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client:   &http.Client{Timeout: 10 * time.Second},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.loop()
+	return r, nil
+}
+
+// RecordSpan implements basictracer.SpanRecorder
+func (r *OTLPRecorder) RecordSpan(span basictracer.RawSpan) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+// Close sends spans recorded so far and stops sending
+func (r *OTLPRecorder) Close() error {
+	close(r.stop)
+	<-r.done
+	return r.Flush()
+}
+
+func (r *OTLPRecorder) loop() {
+	defer close(r.done)
+	ticker := time.NewTicker(otlpFlushPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.Flush()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Flush sends spans recorded so far, spans which failed to be sent are dropped
+func (r *OTLPRecorder) Flush() error {
+	r.mu.Lock()
+	spans := r.spans
+	r.spans = nil
+	r.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Post(r.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP endpoint %s returned %s", r.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// otlpRequest builds ExportTraceServiceRequest in OTLP JSON encoding
+func otlpRequest(spans []basictracer.RawSpan) map[string]interface{} {
+	encoded := make([]map[string]interface{}, 0, len(spans))
+	for _, span := range spans {
+		s := map[string]interface{}{
+			"traceId":           otlpTraceID(span.Context),
+			"spanId":            spanID(span.Context.SpanID),
+			"name":              span.Operation,
+			"kind":              otlpKind(span.Tags),
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.Start.Add(span.Duration).UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Tags),
+		}
+		if span.ParentSpanID != 0 {
+			s["parentSpanId"] = spanID(span.ParentSpanID)
+		}
+		if failed, _ := span.Tags[string(ext.Error)].(bool); failed {
+			s["status"] = map[string]interface{}{"code": 2}
+		}
+		encoded = append(encoded, s)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": "rudder"}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "github.com/kubernetes-helm/rudder-federation"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+func otlpKind(tags map[string]interface{}) int {
+	switch tags[string(ext.SpanKind)] {
+	case ext.SpanKindRPCServerEnum:
+		return 2
+	case ext.SpanKindRPCClientEnum:
+		return 3
+	}
+	return 1
+}
+
+func otlpAttributes(tags map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]interface{}, 0, len(tags))
+	for _, key := range keys {
+		var value map[string]interface{}
+		switch v := tags[key].(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		attributes = append(attributes, map[string]interface{}{"key": key, "value": value})
+	}
+	return attributes
+}
+
+func spanID(id uint64) string {
+	return fmt.Sprintf("%016x", id)
+}
+
+func parentID(id uint64) string {
+	if id == 0 {
+		return ""
+	}
+	return spanID(id)
+}
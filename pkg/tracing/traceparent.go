@@ -0,0 +1,92 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc/metadata"
+)
+
+// traceparentHeader carries span context of the caller in W3C Trace Context format
+const traceparentHeader = "traceparent"
+
+// traceIDHighBaggage keeps the upper half of 16 byte W3C trace IDs, basictracer trace IDs have 8 bytes only.
+// Children inherit it like other baggage, so exported spans stay in the trace of the caller.
+const traceIDHighBaggage = "w3c-trace-id-high"
+
+// callerContext reads span context of the caller from gRPC metadata: from the W3C traceparent header
+// when the caller sent one, from headers of tracer otherwise
+func callerContext(tracer opentracing.Tracer, md metadata.MD) (opentracing.SpanContext, bool) {
+	if values := md[traceparentHeader]; len(values) > 0 {
+		if parent, err := parseTraceparent(values[0]); err == nil {
+			return parent, true
+		}
+	}
+	parent, err := tracer.Extract(opentracing.TextMap, metadataCarrier(md))
+	return parent, err == nil
+}
+
+// parseTraceparent reads version-traceid-parentid-flags, like 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+// Versions after 00 may add fields, which are ignored.
+func parseTraceparent(value string) (basictracer.SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return basictracer.SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+	traceHex, spanHex, flags := parts[1], parts[2], parts[3]
+	if len(traceHex) != 32 || len(spanHex) != 16 || len(flags) != 2 || !isLowerHex(parts[0]+traceHex+spanHex+flags) {
+		return basictracer.SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+
+	high, _ := strconv.ParseUint(traceHex[:16], 16, 64)
+	low, _ := strconv.ParseUint(traceHex[16:], 16, 64)
+	span, _ := strconv.ParseUint(spanHex, 16, 64)
+	sampled, _ := strconv.ParseUint(flags, 16, 8)
+	if (high == 0 && low == 0) || span == 0 {
+		return basictracer.SpanContext{}, fmt.Errorf("traceparent %q has zero IDs", value)
+	}
+
+	parent := basictracer.SpanContext{TraceID: low, SpanID: span, Sampled: sampled&1 == 1}
+	if high != 0 {
+		parent.Baggage = map[string]string{traceIDHighBaggage: traceHex[:16]}
+	}
+	return parent, nil
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// otlpTraceID is the 16 byte trace ID of span context, with the upper half kept from W3C trace context
+// of the caller, zeros otherwise
+func otlpTraceID(spanContext basictracer.SpanContext) string {
+	high := spanContext.Baggage[traceIDHighBaggage]
+	if high == "" {
+		high = "0000000000000000"
+	}
+	return high + spanID(spanContext.TraceID)
+}
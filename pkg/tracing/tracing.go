@@ -0,0 +1,129 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing sets up OpenTracing spans of rudder: a span per RPC, continuing the trace of the caller
+// found in gRPC metadata, with child spans for every step and every cluster of the fan-out
+package tracing
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Exporters spans can be sent to
+const (
+	ExporterNone = "none"
+	ExporterFile = "file"
+	ExporterOTLP = "otlp"
+)
+
+// Setup makes the global tracer send spans to exporter: a file with a span per line for ExporterFile,
+// OTLP/HTTP endpoint for ExporterOTLP. Returned closer flushes and closes the exporter.
+func Setup(exporter, target string) (io.Closer, error) {
+	var recorder interface {
+		basictracer.SpanRecorder
+		io.Closer
+	}
+	var err error
+
+	switch exporter {
+	case "", ExporterNone:
+		return nopCloser{}, nil
+	case ExporterFile:
+		recorder, err = NewFileRecorder(target)
+	case ExporterOTLP:
+		recorder, err = NewOTLPRecorder(target)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, use %s, %s or %s", exporter, ExporterNone, ExporterFile, ExporterOTLP)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	opentracing.SetGlobalTracer(NewTracer(recorder))
+	return recorder, nil
+}
+
+// NewTracer returns tracer which records every span with recorder
+func NewTracer(recorder basictracer.SpanRecorder) opentracing.Tracer {
+	options := basictracer.DefaultOptions()
+	options.Recorder = recorder
+	//Rudder handles few long requests, each one is worth keeping
+	options.ShouldSample = func(traceID uint64) bool { return true }
+	return basictracer.NewWithOptions(options)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// metadataCarrier reads and writes span context in gRPC metadata
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Set(key, value string) {
+	key = strings.ToLower(key)
+	c[key] = append(c[key], value)
+}
+
+func (c metadataCarrier) ForeachKey(handler func(key, value string) error) error {
+	for key, values := range c {
+		for _, value := range values {
+			if err := handler(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UnaryServerInterceptor starts a span for every RPC, as a child of span context of the caller if it sent one,
+// either as W3C traceparent or in headers of the tracer
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	tracer := opentracing.GlobalTracer()
+	options := []opentracing.StartSpanOption{ext.SpanKindRPCServer}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if parent, ok := callerContext(tracer, md); ok {
+			options = append(options, opentracing.ChildOf(parent))
+		}
+	}
+
+	span := tracer.StartSpan(info.FullMethod, options...)
+	resp, err := handler(opentracing.ContextWithSpan(ctx, span), req)
+	Finish(span, err)
+	return resp, err
+}
+
+// StartSpan starts span named operation as a child of span in ctx
+func StartSpan(ctx context.Context, operation string) (opentracing.Span, context.Context) {
+	return opentracing.StartSpanFromContext(ctx, operation)
+}
+
+// Finish finishes span, marking it failed when err is set
+func Finish(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogKV("event", "error", "message", err.Error())
+	}
+	span.Finish()
+}
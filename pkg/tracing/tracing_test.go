@@ -0,0 +1,264 @@
+/*
+Copyright 2017 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	saved := opentracing.GlobalTracer()
+	defer opentracing.SetGlobalTracer(saved)
+	recorder := basictracer.NewInMemoryRecorder()
+	tracer := NewTracer(recorder)
+	opentracing.SetGlobalTracer(tracer)
+
+	caller := tracer.StartSpan("helm upgrade")
+	md := metadata.MD{}
+	if err := tracer.Inject(caller.Context(), opentracing.TextMap, metadataCarrier(md)); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/hapi.services.rudder.ReleaseModuleService/UpgradeRelease"}
+	_, err := UnaryServerInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		span, _ := StartSpan(ctx, "update")
+		span.SetTag("cluster", "cluster-a")
+		Finish(span, errors.New("timed out"))
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	caller.Finish()
+
+	spans := recorder.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+	update, rpc, root := spans[0], spans[1], spans[2]
+	if rpc.Operation != info.FullMethod || rpc.ParentSpanID != root.Context.SpanID {
+		t.Errorf("Expected RPC span to be a child of the caller, got %+v", rpc)
+	}
+	if update.ParentSpanID != rpc.Context.SpanID || update.Context.TraceID != root.Context.TraceID {
+		t.Errorf("Expected cluster span to be a child of RPC span in the same trace, got %+v", update)
+	}
+	if update.Tags["error"] != true || update.Tags["cluster"] != "cluster-a" {
+		t.Errorf("Expected failed span with cluster tag, got %v", update.Tags)
+	}
+}
+
+func TestOTLPRecorder(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("Expected spans sent to /v1/traces, got %s", r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			t.Errorf("Expected JSON, got %v", err)
+		}
+	}))
+	defer server.Close()
+
+	recorder, err := NewOTLPRecorder(server.URL)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	span := NewTracer(recorder).StartSpan("install")
+	span.SetTag("cluster", "cluster-a")
+	span.Finish()
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	spans := received["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if len(spans) != 1 {
+		t.Fatalf("Expected a single span, got %v", spans)
+	}
+	sent := spans[0].(map[string]interface{})
+	if sent["name"] != "install" || len(sent["traceId"].(string)) != 32 || len(sent["spanId"].(string)) != 16 {
+		t.Errorf("Expected install span with OTLP ids, got %v", sent)
+	}
+}
+
+// w3cExample is the traceparent example of W3C Trace Context specification
+const w3cExample = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	parent, err := parseTraceparent(w3cExample)
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if parent.TraceID != 0xa3ce929d0e0e4736 || parent.SpanID != 0x00f067aa0ba902b7 || !parent.Sampled {
+		t.Errorf("Expected sampled span context of the example, got %+v", parent)
+	}
+	if id := otlpTraceID(parent); id != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the whole trace ID to be kept, got %s", id)
+	}
+
+	valid := []string{
+		"00-00000000000000000000000000000001-00f067aa0ba902b7-00",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future",
+	}
+	for _, value := range valid {
+		if _, err := parseTraceparent(value); err != nil {
+			t.Errorf("Expected %s to be valid, got %v", value, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	}
+	for _, value := range invalid {
+		if _, err := parseTraceparent(value); err == nil {
+			t.Errorf("Expected %q to be invalid", value)
+		}
+	}
+}
+
+func TestUnaryServerInterceptorTraceparent(t *testing.T) {
+	saved := opentracing.GlobalTracer()
+	defer opentracing.SetGlobalTracer(saved)
+	recorder := basictracer.NewInMemoryRecorder()
+	opentracing.SetGlobalTracer(NewTracer(recorder))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(traceparentHeader, w3cExample))
+	info := &grpc.UnaryServerInfo{FullMethod: "/hapi.services.rudder.ReleaseModuleService/InstallRelease"}
+	_, err := UnaryServerInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		span, _ := StartSpan(ctx, "install")
+		Finish(span, nil)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+
+	spans := recorder.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	install, rpc := spans[0], spans[1]
+	if rpc.ParentSpanID != 0x00f067aa0ba902b7 {
+		t.Errorf("Expected RPC span to be a child of the caller, got parent %x", rpc.ParentSpanID)
+	}
+	for _, span := range []basictracer.RawSpan{rpc, install} {
+		if id := otlpTraceID(span.Context); id != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Expected %s span in the trace of the caller, got %s", span.Operation, id)
+		}
+	}
+}
+
+// otlpPayload is ExportTraceServiceRequest as an OpenTelemetry collector accepts it on /v1/traces in JSON encoding
+const otlpPayload = `{
+  "resourceSpans": [{
+    "resource": {
+      "attributes": [{"key": "service.name", "value": {"stringValue": "rudder"}}]
+    },
+    "scopeSpans": [{
+      "scope": {"name": "github.com/kubernetes-helm/rudder-federation"},
+      "spans": [{
+        "traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+        "spanId": "00f067aa0ba902b7",
+        "parentSpanId": "53995c3f42cd8ad8",
+        "name": "update",
+        "kind": 2,
+        "startTimeUnixNano": "1544712660000000000",
+        "endTimeUnixNano": "1544712661500000000",
+        "attributes": [
+          {"key": "attempt", "value": {"intValue": "2"}},
+          {"key": "cluster", "value": {"stringValue": "cluster-a"}},
+          {"key": "error", "value": {"boolValue": true}},
+          {"key": "span.kind", "value": {"stringValue": "server"}}
+        ],
+        "status": {"code": 2}
+      }, {
+        "traceId": "00000000000000000000000000000001",
+        "spanId": "0000000000000002",
+        "name": "rewrite",
+        "kind": 1,
+        "startTimeUnixNano": "1544712660000000000",
+        "endTimeUnixNano": "1544712660000000000",
+        "attributes": []
+      }]
+    }]
+  }]
+}`
+
+func TestOTLPRequestPayload(t *testing.T) {
+	start := time.Unix(1544712660, 0)
+	spans := []basictracer.RawSpan{
+		{
+			Context: basictracer.SpanContext{
+				TraceID: 0xa3ce929d0e0e4736,
+				SpanID:  0x00f067aa0ba902b7,
+				Baggage: map[string]string{traceIDHighBaggage: "4bf92f3577b34da6"},
+			},
+			ParentSpanID: 0x53995c3f42cd8ad8,
+			Operation:    "update",
+			Start:        start,
+			Duration:     1500 * time.Millisecond,
+			Tags: opentracing.Tags{
+				string(ext.SpanKind): ext.SpanKindRPCServerEnum,
+				"cluster":            "cluster-a",
+				"attempt":            2,
+				string(ext.Error):    true,
+			},
+		},
+		{
+			Context:   basictracer.SpanContext{TraceID: 1, SpanID: 2},
+			Operation: "rewrite",
+			Start:     start,
+		},
+	}
+
+	encoded, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	var got, expected interface{}
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if err := json.Unmarshal([]byte(otlpPayload), &expected); err != nil {
+		t.Fatalf("Expected no errors, got %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected OTLP payload\n%s\ngot\n%s", otlpPayload, encoded)
+	}
+}